@@ -0,0 +1,127 @@
+package bench
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Backend interface {
+	Update(fn func(txn Txn) error) error
+	View(fn func(txn Txn) error) error
+	// Batch is like Update but may coalesce concurrent calls into one
+	// transaction, fn can be called more than once
+	Batch(fn func(txn Txn) error) error
+	// Sync flushes commits made without fsync to disk
+	Sync() error
+	Close() error
+}
+
+type Txn interface {
+	// Bucket returns nil if the bucket does not exist
+	Bucket(name []byte) Bucket
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+}
+
+type Bucket interface {
+	Get(key []byte) []byte
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+	NextSequence() (uint64, error)
+	Sequence() uint64
+	SetSequence(v uint64) error
+	ForEach(fn func(k, v []byte) error) error
+	Cursor() Cursor
+	// Bucket returns the nested bucket name, nil if it does not exist
+	Bucket(name []byte) Bucket
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+}
+
+type Cursor interface {
+	First() (key []byte, value []byte)
+	Last() (key []byte, value []byte)
+	Next() (key []byte, value []byte)
+	Prev() (key []byte, value []byte)
+	Seek(seek []byte) (key []byte, value []byte)
+}
+
+// StatsBackend is implemented by backends that can describe their tree layout
+type StatsBackend interface {
+	BucketStats(name []byte) (BucketStats, error)
+	// FreePages returns the number of pages on the freelist
+	FreePages() int
+	// PageSize returns the page size of the database file
+	PageSize() int
+	// EngineStats returns the freelist and the transaction counters, which
+	// accumulate over the lifetime of the database handle
+	EngineStats() EngineStats
+}
+
+// EngineStats mirrors the bolt DB.Stats. The Sub of two snapshots holds the
+// counters of the window between them and the freelist of the later one.
+type EngineStats struct {
+	FreePages     int           `json:"free_pages"`
+	PendingPages  int           `json:"pending_pages"`
+	OpenTxns      int           `json:"open_read_txns"`
+	ReadTxns      int64         `json:"read_txns"`
+	PageCount     int64         `json:"page_allocs"`
+	PageAlloc     int64         `json:"page_alloc_bytes"`
+	CursorCount   int64         `json:"cursors"`
+	NodeCount     int64         `json:"node_allocs"`
+	NodeDeref     int64         `json:"node_derefs"`
+	Rebalance     int64         `json:"rebalances"`
+	RebalanceTime time.Duration `json:"rebalance_ns"`
+	Split         int64         `json:"splits"`
+	Spill         int64         `json:"spills"`
+	SpillTime     time.Duration `json:"spill_ns"`
+	Write         int64         `json:"writes"`
+	WriteTime     time.Duration `json:"write_ns"`
+	Commits       int64         `json:"commits"`
+}
+
+func (s EngineStats) Sub(before EngineStats) EngineStats {
+	s.ReadTxns -= before.ReadTxns
+	s.PageCount -= before.PageCount
+	s.PageAlloc -= before.PageAlloc
+	s.CursorCount -= before.CursorCount
+	s.NodeCount -= before.NodeCount
+	s.NodeDeref -= before.NodeDeref
+	s.Rebalance -= before.Rebalance
+	s.RebalanceTime -= before.RebalanceTime
+	s.Split -= before.Split
+	s.Spill -= before.Spill
+	s.SpillTime -= before.SpillTime
+	s.Write -= before.Write
+	s.WriteTime -= before.WriteTime
+	s.Commits -= before.Commits
+	return s
+}
+
+type BucketStats struct {
+	Name          string `json:"name"`
+	Keys          int    `json:"keys"`
+	Depth         int    `json:"depth"`
+	BranchPages   int    `json:"branch_pages"`
+	LeafPages     int    `json:"leaf_pages"`
+	OverflowPages int    `json:"overflow_pages"`
+	InlineBuckets int    `json:"inline_buckets"`
+	LeafInuse     int    `json:"leaf_inuse_bytes"`
+}
+
+var backends = map[string]func(path string, o Options) (Backend, error){
+	"bolt": openBolt,
+}
+
+func openBackend(name string, path string, o Options) (Backend, error) {
+	open, ok := backends[name]
+	if !ok {
+		names := make([]string, 0, len(backends))
+		for n := range backends {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("unknown backend %q, available: %s", name, strings.Join(names, ", "))
+	}
+	return open(path, o)
+}
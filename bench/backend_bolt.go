@@ -0,0 +1,197 @@
+package bench
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// boltBackend counts the commits of write transactions, which bolt does not.
+// The functions of a Batch share one transaction, lastBatch keeps a shared
+// commit from being counted once per function.
+type boltBackend struct {
+	db        *boltDB
+	commits   atomic.Int64
+	lastBatch atomic.Pointer[boltTx]
+}
+
+// BoltDB is the database handle of the bolt fork compiled in, EngineModule
+type BoltDB = boltDB
+
+// NewBolt returns the Backend of a database opened by the caller, who keeps
+// the options it was opened with
+func NewBolt(db *BoltDB) Backend {
+	return &boltBackend{db: db}
+}
+
+// EngineVersion returns the version of the bolt fork compiled in
+func EngineVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == EngineModule {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+func openBolt(path string, o Options) (Backend, error) {
+	options := &boltOptions{
+		NoGrowSync:      o.Bolt.NoGrowSync,
+		InitialMmapSize: o.Bolt.InitialMmap,
+		MmapFlags:       o.Bolt.MmapFlags,
+	}
+	if err := setEngineOptions(options, o.Bolt); err != nil {
+		return nil, err
+	}
+	slog.Info("bolt engine", "module", EngineModule, "version", EngineVersion())
+	db, err := openEngine(path, options)
+	if err != nil {
+		return nil, err
+	}
+	db.NoSync = o.EffectiveSyncMode() != "always"
+	slog.Info("bolt options",
+		"sync-mode", o.EffectiveSyncMode(),
+		"nosync", db.NoSync,
+		"nogrowsync", db.NoGrowSync,
+		"initial-mmap", options.InitialMmapSize,
+		"mmap-flags", db.MmapFlags)
+	return &boltBackend{db: db}, nil
+}
+
+func (b *boltBackend) countCommit() {
+	b.commits.Add(1)
+}
+
+func (b *boltBackend) Update(fn func(txn Txn) error) error {
+	return b.db.Update(func(tx *boltTx) error {
+		tx.OnCommit(b.countCommit)
+		return fn(boltTxn{tx})
+	})
+}
+
+func (b *boltBackend) View(fn func(txn Txn) error) error {
+	return b.db.View(func(tx *boltTx) error {
+		return fn(boltTxn{tx})
+	})
+}
+
+func (b *boltBackend) Batch(fn func(txn Txn) error) error {
+	return b.db.Batch(func(tx *boltTx) error {
+		if b.lastBatch.Swap(tx) != tx {
+			tx.OnCommit(b.countCommit)
+		}
+		return fn(boltTxn{tx})
+	})
+}
+
+func (b *boltBackend) BucketStats(name []byte) (BucketStats, error) {
+	var rv BucketStats
+	err := b.db.View(func(tx *boltTx) error {
+		bucket := tx.Bucket(name)
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", name)
+		}
+		st := bucket.Stats()
+		rv = BucketStats{
+			Name:          string(name),
+			Keys:          st.KeyN,
+			Depth:         st.Depth,
+			BranchPages:   st.BranchPageN,
+			LeafPages:     st.LeafPageN,
+			OverflowPages: st.BranchOverflowN + st.LeafOverflowN,
+			InlineBuckets: st.InlineBucketN,
+			LeafInuse:     st.LeafInuse,
+		}
+		return nil
+	})
+	return rv, err
+}
+
+func (b *boltBackend) FreePages() int {
+	return b.db.Stats().FreePageN
+}
+
+func (b *boltBackend) EngineStats() EngineStats {
+	st := b.db.Stats()
+	tx := st.TxStats
+	return EngineStats{
+		FreePages:     st.FreePageN,
+		PendingPages:  st.PendingPageN,
+		OpenTxns:      st.OpenTxN,
+		ReadTxns:      int64(st.TxN),
+		PageCount:     int64(tx.PageCount),
+		PageAlloc:     int64(tx.PageAlloc),
+		CursorCount:   int64(tx.CursorCount),
+		NodeCount:     int64(tx.NodeCount),
+		NodeDeref:     int64(tx.NodeDeref),
+		Rebalance:     int64(tx.Rebalance),
+		RebalanceTime: tx.RebalanceTime,
+		Split:         int64(tx.Split),
+		Spill:         int64(tx.Spill),
+		SpillTime:     tx.SpillTime,
+		Write:         int64(tx.Write),
+		WriteTime:     tx.WriteTime,
+		Commits:       b.commits.Load(),
+	}
+}
+
+func (b *boltBackend) PageSize() int {
+	return b.db.Info().PageSize
+}
+
+func (b *boltBackend) Sync() error {
+	return b.db.Sync()
+}
+
+func (b *boltBackend) Close() error {
+	return b.db.Close()
+}
+
+type boltTxn struct {
+	tx *boltTx
+}
+
+func (t boltTxn) Bucket(name []byte) Bucket {
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+	return boltBucket{b}
+}
+
+func (t boltTxn) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return boltBucket{b}, nil
+}
+
+type boltBucket struct {
+	*boltRawBucket
+}
+
+func (b boltBucket) Cursor() Cursor {
+	return b.boltRawBucket.Cursor()
+}
+
+func (b boltBucket) Bucket(name []byte) Bucket {
+	nested := b.boltRawBucket.Bucket(name)
+	if nested == nil {
+		return nil
+	}
+	return boltBucket{nested}
+}
+
+func (b boltBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	nested, err := b.boltRawBucket.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return boltBucket{nested}, nil
+}
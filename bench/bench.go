@@ -0,0 +1,396 @@
+// Package bench fills a bolt database with TPC-B like tables and measures
+// workloads against it. Open a Bench with Options, pick a Workload with
+// SelectWorkload and Measure it; ReadWrite, Read and Fill are the TPC-B
+// operations on their own.
+package bench
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	accountPrefix = []byte("accounts:")
+	tellerPrefix  = []byte("tellers:")
+	branchPrefix  = []byte("branches:")
+	historyPrefix = []byte("history:")
+
+	allTables = [][]byte{accountPrefix, tellerPrefix, branchPrefix, historyPrefix}
+)
+
+func hasTable(tables [][]byte, table []byte) bool {
+	return slices.ContainsFunc(tables, func(t []byte) bool { return bytes.Equal(t, table) })
+}
+
+var errNotFound = errors.New("not found")
+
+// Bench runs the workloads of its Options against one database. The
+// counters are those of the measured window in progress or last run.
+type Bench struct {
+	opts  Options
+	db    Backend
+	codec Codec
+	// timing holds the -time-breakdown counters, nil without it
+	timing *timing
+
+	// historyFiller is the filler of history records. Unlike table fills it
+	// is the same string for every record, generating one per insert would
+	// be measured as part of the workload.
+	historyFiller string
+	// historyCounter hands out history keys under -history-key counter. It
+	// starts from the bucket sequence and is written back to it around the
+	// measured window, so the checks reading the sequence keep working.
+	historyCounter atomic.Uint64
+	// appliedDelta sums the deltas of the committed tpcb writes since the
+	// balances were taken before the run
+	appliedDelta atomic.Int64
+
+	conflicts atomic.Uint64
+	opErrors  atomic.Uint64
+	readOps   atomic.Uint64
+	writeOps  atomic.Uint64
+	errLog    throttledLogger
+
+	live struct {
+		sync.Mutex
+		iterations *atomic.Uint64
+		histograms []Histogram
+	}
+}
+
+// throttledLogger logs the first few errors, then at most one per second
+type throttledLogger struct {
+	seen atomic.Uint64
+	last atomic.Int64
+}
+
+func (t *throttledLogger) Log(err error) {
+	seen := t.seen.Add(1)
+	if seen <= 10 {
+		slog.Error("operation failed", "err", err)
+		return
+	}
+	now := time.Now().UnixNano()
+	last := t.last.Load()
+	if now-last < int64(time.Second) || !t.last.CompareAndSwap(last, now) {
+		return
+	}
+	slog.Error("operation failed", "err", err, "seen", seen)
+}
+
+type Account struct {
+	AID      int    `db:"aid"`
+	BID      int64  `db:"bid"`
+	Abalance int64  `db:"abalance"`
+	Filler   string `db:"filler"`
+}
+
+type Teller struct {
+	TID      int    `db:"tid"`
+	BID      int64  `db:"bid"`
+	Tbalance int64  `db:"tbalance"`
+	Filler   string `db:"filler"`
+}
+
+type Branche struct {
+	BID      int    `db:"bid"`
+	Bbalance int64  `db:"bbalance"`
+	Filler   string `db:"filler"`
+}
+
+type History struct {
+	TID    int64     `db:"tid"`
+	BID    int64     `db:"bid"`
+	AID    int64     `db:"aid"`
+	Delta  int64     `db:"delta"`
+	Mtime  time.Time `db:"mtime"`
+	Filler string    `db:"filler"`
+}
+
+// keyFor returns the id as 8 big-endian bytes. bolt orders keys bytewise, so
+// this keeps keys in numeric order and a cursor seek to an id starts a numeric
+// id range. Databases filled with the former decimal string keys, where "10"
+// sorted before "2", are rejected by checkKeyFormat and must be filled again.
+func keyFor(id int) []byte {
+	return binary.BigEndian.AppendUint64(make([]byte, 0, 8), uint64(id))
+}
+
+func idFor(key []byte) (int, error) {
+	if len(key) != 8 {
+		return 0, fmt.Errorf("key %q is not 8 bytes, the database was filled with decimal keys and must be filled again", key)
+	}
+	return int(binary.BigEndian.Uint64(key)), nil
+}
+
+// checkKeyFormat fails on databases filled before keys became fixed width
+func checkKeyFormat(db Backend) error {
+	return db.View(func(txn Txn) error {
+		for _, table := range allTables {
+			b := txn.Bucket(table)
+			if b == nil {
+				continue
+			}
+			if k, _ := b.Cursor().First(); k != nil {
+				if _, err := idFor(k); err != nil {
+					return fmt.Errorf("%s %w", table, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (bn *Bench) valueFor(val interface{}) []byte {
+	rv, err := bn.codec.Marshal(val)
+	if err != nil {
+		panic(err)
+	}
+	return rv
+}
+
+// countKeys returns the number of keys in the table
+func countKeys(db Backend, prefix []byte) (int, error) {
+	keys := 0
+	err := db.View(func(txn Txn) error {
+		c := txn.Bucket(prefix).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys++
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// scanRange returns the records with ids in [from, to) in id order
+func scanRange[T any](bn *Bench, prefix []byte, from, to int) ([]T, error) {
+	var records []T
+	err := bn.db.View(func(txn Txn) error {
+		c := txn.Bucket(prefix).Cursor()
+		for k, v := c.Seek(keyFor(from)); k != nil; k, v = c.Next() {
+			id, err := idFor(k)
+			if err != nil {
+				return fmt.Errorf("%s: %w", prefix, err)
+			}
+			if id >= to {
+				break
+			}
+			var rec T
+			if err := bn.codec.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("%s%d: %w", prefix, id, err)
+			}
+			records = append(records, rec)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// tableRows returns the number of rows in the table, capped at limit. ids are
+// written in order, so the last one being present means the table is complete
+// and the full count can be skipped.
+func tableRows(db Backend, prefix []byte, limit int) (int, error) {
+	var complete bool
+	err := db.View(func(txn Txn) error {
+		complete = limit > 0 && txn.Bucket(prefix).Get(keyFor(limit-1)) != nil
+		return nil
+	})
+	if err != nil || complete {
+		return limit, err
+	}
+	rows, err := countKeys(db, prefix)
+	return min(rows, limit), err
+}
+
+// checkDataset checks the row counts of the filled tables among tables
+func (bn *Bench) checkDataset(tables [][]byte) error {
+	accounts, tellers, branches := bn.opts.tableSizes()
+	type filled struct {
+		prefix []byte
+		want   int
+	}
+	expected := []filled{
+		{accountPrefix, accounts},
+		{tellerPrefix, tellers},
+		{branchPrefix, branches},
+	}
+	for _, b := range bn.opts.Schema {
+		expected = append(expected, filled{[]byte(b.Name), b.Keys})
+	}
+	for _, t := range expected {
+		if !hasTable(tables, t.prefix) {
+			continue
+		}
+		found, err := tableRows(bn.db, t.prefix, t.want)
+		if err != nil {
+			return err
+		}
+		if found < t.want {
+			return fmt.Errorf("need %d %s, found %d: fill the database with -init or lower -scale or -accounts-per-scale",
+				t.want, strings.TrimSuffix(string(t.prefix), ":"), found)
+		}
+	}
+	return nil
+}
+
+// createTables creates the missing tables among tables under -init. Without
+// it the database is used as is, so a missing table, most likely from
+// pointing -db at the wrong file, is an error rather than a nil bucket later.
+func (bn *Bench) createTables(tables [][]byte) error {
+	if bn.opts.Init {
+		return bn.db.Update(func(txn Txn) error {
+			for _, table := range tables {
+				if _, err := txn.CreateBucketIfNotExists(table); err != nil {
+					return fmt.Errorf("bucket %s: %w", strings.TrimSuffix(string(table), ":"), err)
+				}
+			}
+			return nil
+		})
+	}
+	return bn.db.View(func(txn Txn) error {
+		for _, table := range tables {
+			if txn.Bucket(table) == nil {
+				return fmt.Errorf("bucket %s: %w; run with -init", strings.TrimSuffix(string(table), ":"), errNotFound)
+			}
+		}
+		return nil
+	})
+}
+
+// Open opens the database of o and sets it up like New
+func Open(o Options, tables [][]byte) (*Bench, error) {
+	if err := o.Check(); err != nil {
+		return nil, err
+	}
+	db, err := openBackend(o.Backend, o.DBPath, o)
+	if err != nil {
+		return nil, err
+	}
+	bn, err := New(o, db, tables)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return bn, nil
+}
+
+// New runs the workloads of o against db, which it takes over. It creates
+// and fills only the tables the run uses, see Options.Tables, and checks
+// that they hold the rows o asks for.
+func New(o Options, db Backend, tables [][]byte) (*Bench, error) {
+	if err := o.Check(); err != nil {
+		return nil, err
+	}
+	if o.Seed == 0 {
+		o.Seed = rand.Uint64() | 1
+		slog.Info("using seed", "seed", o.Seed)
+	}
+	bn := &Bench{opts: o, codec: codecs[o.Encoding], db: db}
+	if o.Shards > 1 {
+		bn.db = newShardedBackend(bn.db, o.Shards)
+	}
+	if err := checkLayout(bn.db, o.Nested); err != nil {
+		return nil, err
+	}
+	if o.Nested {
+		bn.db = newNestedBackend(bn.db, max(1, o.AccountsPerScale/o.BranchesPerScale))
+	}
+
+	if err := bn.createTables(tables); err != nil {
+		return nil, err
+	}
+
+	if err := checkKeyFormat(bn.db); err != nil {
+		return nil, err
+	}
+
+	attrs := []any{"db", o.DBPath}
+	for _, table := range tables {
+		keys, err := countKeys(bn.db, table)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, strings.TrimSuffix(string(table), ":"), keys)
+	}
+	slog.Info("existing keys", attrs...)
+
+	if o.Init {
+		slog.Info("filling...", "db", o.DBPath, "scale", o.Scale)
+		if err := bn.Fill(tables); err != nil {
+			return nil, err
+		}
+	}
+	if sb, ok := bn.db.(StatsBackend); ok {
+		logPageFit(o, sb.PageSize(), tables)
+	}
+	if err := bn.checkDataset(tables); err != nil {
+		return nil, err
+	}
+	if o.TimeBreakdown {
+		bn.timing = &timing{}
+		bn.db = newTimedBackend(bn.db, bn.timing)
+	}
+	bn.historyFiller = bn.fillerSource(0, o.fillSizeOf(o.FillSizeHistory))()
+	if err := bn.loadHistoryCounter(); err != nil {
+		return nil, err
+	}
+	return bn, nil
+}
+
+// Options returns the options bn runs with, with the seed it picked if the
+// given one was 0
+func (bn *Bench) Options() Options {
+	return bn.opts
+}
+
+// Close closes the database
+func (bn *Bench) Close() error {
+	return bn.db.Close()
+}
+
+// Live returns the operations and errors of the measured window in progress
+// and the histogram of their latencies, for serving them while it runs
+func (bn *Bench) Live() (iterations, failed uint64, histogram *Histogram) {
+	bn.live.Lock()
+	defer bn.live.Unlock()
+	histogram = &Histogram{}
+	for i := range bn.live.histograms {
+		histogram.Add(&bn.live.histograms[i])
+	}
+	if bn.live.iterations != nil {
+		iterations = bn.live.iterations.Load()
+	}
+	return iterations, bn.opErrors.Load(), histogram
+}
+
+// setLive points Live at the counters of the window starting
+func (bn *Bench) setLive(iterations *atomic.Uint64, histograms []Histogram) {
+	bn.live.Lock()
+	defer bn.live.Unlock()
+	bn.live.iterations = iterations
+	bn.live.histograms = histograms
+}
+
+func (bn *Bench) getRecord(b Bucket, prefix []byte, id int, val interface{}) error {
+	raw := b.Get(keyFor(id))
+	if raw == nil {
+		return fmt.Errorf("%s%d: %w", prefix, id, errNotFound)
+	}
+	if err := bn.codec.Unmarshal(raw, val); err != nil {
+		return fmt.Errorf("%s%d: %w", prefix, id, err)
+	}
+	return nil
+}
+
+// randomDelta returns a balance change between -delta-min and -delta-max
+func (bn *Bench) randomDelta(rng *Rng) int64 {
+	return bn.opts.DeltaMin + rng.Int64N(bn.opts.DeltaMax-bn.opts.DeltaMin+1)
+}
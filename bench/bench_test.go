@@ -0,0 +1,457 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/samber/lo"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testOptions are the options of a fresh database of 100 accounts, 10
+// tellers and 3 branches in a temporary directory, filled when opened
+func testOptions(t testing.TB) Options {
+	t.Helper()
+	o := DefaultOptions()
+	o.DBPath = filepath.Join(t.TempDir(), "test.db")
+	o.Scale = 1
+	o.AccountsPerScale = 100
+	o.TellersPerScale = 10
+	o.BranchesPerScale = 3
+	o.Seed = 1
+	return o
+}
+
+// openTest opens a Bench of o with tables, closing it when the test ends
+func openTest(t testing.TB, o Options, tables [][]byte) *Bench {
+	t.Helper()
+	bn, err := Open(o, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bn.Close() })
+	return bn
+}
+
+func TestReadWriteTxnUpdatesBranchByBid(t *testing.T) {
+	bn := openTest(t, testOptions(t), allTables)
+	const aid, tid, bid, delta = 7, 5, 1, 42
+	err := bn.db.Update(func(txn Txn) error {
+		return bn.readWriteTxn(txn, []accountUpdate{{aid, delta}}, tid, bid)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bn.db.View(func(txn Txn) error {
+		b := txn.Bucket(branchPrefix)
+		if v := b.Get(keyFor(tid)); v != nil {
+			t.Errorf("branch key %d written by the teller id: %s", tid, v)
+		}
+		for id := range 3 {
+			var branch Branche
+			if err := bn.getRecord(b, branchPrefix, id, &branch); err != nil {
+				return err
+			}
+			want := int64(0)
+			if id == bid {
+				want = delta
+			}
+			if branch.Bbalance != want {
+				t.Errorf("branch %d balance %d, want %d", id, branch.Bbalance, want)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFillTableWritesExactlyLimitRows(t *testing.T) {
+	// 1000 divides the limit into whole batches, 700 leaves a short last one
+	for _, batch := range []int{1000, 700} {
+		t.Run(fmt.Sprintf("batch=%d", batch), func(t *testing.T) {
+			o := testOptions(t)
+			o.FillBatch = batch
+			bn := openTest(t, o, nil)
+			err := bn.db.Update(func(txn Txn) error {
+				_, err := txn.CreateBucketIfNotExists(accountPrefix)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			const limit = 2500
+			created, err := bn.fillTable(accountPrefix, limit, 0, func(it int, filler string) []byte {
+				return bn.valueFor(Account{AID: it, Filler: filler})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created != limit {
+				t.Errorf("fillTable returned %d, want %d", created, limit)
+			}
+			want := 0
+			err = bn.db.View(func(txn Txn) error {
+				return txn.Bucket(accountPrefix).ForEach(func(k, v []byte) error {
+					id, err := idFor(k)
+					if err != nil {
+						return err
+					}
+					if id != want {
+						return fmt.Errorf("key %d at position %d", id, want)
+					}
+					want++
+					return nil
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want != limit {
+				t.Errorf("%d keys, want %d", want, limit)
+			}
+		})
+	}
+}
+
+func TestReadWriteTxnAddsOneHistoryRowPerCall(t *testing.T) {
+	const n = 50
+	bn := openTest(t, testOptions(t), allTables)
+	commits := map[string]func(fn func(txn Txn) error) error{"update": bn.db.Update, "batch": bn.db.Batch}
+	total := 0
+	for _, name := range []string{"update", "batch"} {
+		t.Run(name, func(t *testing.T) {
+			// concurrent calls, so that Batch combines them into shared
+			// transactions
+			var wg sync.WaitGroup
+			errs := make(chan error, n)
+			for i := range n {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- commits[name](func(txn Txn) error {
+						return bn.readWriteTxn(txn, []accountUpdate{{i % 100, 1}}, i%10, i%3)
+					})
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			total += n
+			var rows int
+			var seq uint64
+			err := bn.db.View(func(txn Txn) error {
+				b := txn.Bucket(historyPrefix)
+				seq = b.Sequence()
+				return b.ForEach(func(k, v []byte) error {
+					rows++
+					return nil
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rows != total || seq != uint64(total) {
+				t.Errorf("%d history rows and sequence %d after %d calls", rows, seq, total)
+			}
+		})
+	}
+}
+
+func TestRetryDelayStaysWithinMax(t *testing.T) {
+	bn := &Bench{opts: Options{RetryBackoff: time.Millisecond, RetryBackoffMax: 100 * time.Millisecond}}
+	for retry, want := range map[int]time.Duration{0: time.Millisecond, 3: 8 * time.Millisecond, 7: 100 * time.Millisecond, 44: 100 * time.Millisecond, 1000: 100 * time.Millisecond} {
+		if got := bn.retryDelay(retry); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", retry, got, want)
+		}
+	}
+}
+
+func TestScanRangeReturnsIdsInNumericOrder(t *testing.T) {
+	for name, layout := range map[string]func(o *Options){
+		"flat":    func(o *Options) {},
+		"sharded": func(o *Options) { o.Shards = 3 },
+		"nested":  func(o *Options) { o.Nested = true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			o := testOptions(t)
+			layout(&o)
+			bn := openTest(t, o, [][]byte{accountPrefix})
+			// crosses 9 to 10 and the branch boundary of nested accounts at 33,
+			// which sort wrongly as decimal strings
+			accounts, err := scanRange[Account](bn, accountPrefix, 8, 40)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(accounts) != 32 {
+				t.Fatalf("%d accounts, want 32", len(accounts))
+			}
+			for i, acc := range accounts {
+				if acc.AID != 8+i {
+					t.Errorf("account %d at position %d, want %d", acc.AID, i, 8+i)
+				}
+			}
+		})
+	}
+}
+
+func TestTablesCoverTheSchemaTables(t *testing.T) {
+	o := testOptions(t)
+	o.Schema = []SchemaBucket{{Name: "users", Keys: 50, ValueSize: 10}}
+	var workloads []Workload
+	for _, name := range []string{"schema", "point-read"} {
+		wl, err := SelectWorkload(o, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		workloads = append(workloads, wl)
+	}
+	tables := o.Tables(workloads...)
+	want := [][]byte{accountPrefix, []byte("users")}
+	if !slices.EqualFunc(tables, want, bytes.Equal) {
+		t.Fatalf("tables %q, want %q", tables, want)
+	}
+	bn := openTest(t, o, tables)
+	if n, err := countKeys(bn.db, []byte("users")); err != nil || n != 50 {
+		t.Errorf("%d keys in users, want 50: %v", n, err)
+	}
+}
+
+func TestHistoryCompactorTrimsToTheLiveCounter(t *testing.T) {
+	o := testOptions(t)
+	o.HistoryKey = "counter"
+	o.HistoryRetention = 10
+	bn := openTest(t, o, allTables)
+	// the bucket sequence stays at 0 until counterSync stores the counter
+	for i := range 50 {
+		err := bn.db.Update(func(txn Txn) error { return bn.readWriteTxn(txn, []accountUpdate{{i % 100, 1}}, i%10, i%3) })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &historyCompactor{bn: bn}
+	if n, err := h.trim(); err != nil || n != 40 {
+		t.Fatalf("trimmed %d rows, want 40: %v", n, err)
+	}
+	if n, err := countKeys(bn.db, historyPrefix); err != nil || n != 10 {
+		t.Errorf("%d history rows left, want 10: %v", n, err)
+	}
+}
+
+func TestResumedFillMatchesAnUninterruptedOne(t *testing.T) {
+	fillAccounts := func(bn *Bench, limit int) error {
+		err := bn.db.Update(func(txn Txn) error {
+			_, err := txn.CreateBucketIfNotExists(accountPrefix)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		_, err = bn.fillTable(accountPrefix, limit, 50, func(it int, filler string) []byte {
+			return bn.valueFor(Account{AID: it, Filler: filler})
+		})
+		return err
+	}
+	dump := func(bn *Bench) map[string]string {
+		rows := make(map[string]string)
+		err := bn.db.View(func(txn Txn) error {
+			return txn.Bucket(accountPrefix).ForEach(func(k, v []byte) error {
+				rows[string(k)] = string(v)
+				return nil
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	newOptions := func() Options {
+		o := testOptions(t)
+		o.FillerMode = "random"
+		o.FillBatch = 300
+		return o
+	}
+
+	whole := openTest(t, newOptions(), nil)
+	if err := fillAccounts(whole, 1000); err != nil {
+		t.Fatal(err)
+	}
+	resumed := openTest(t, newOptions(), nil)
+	// the interrupted fill
+	if err := fillAccounts(resumed, 400); err != nil {
+		t.Fatal(err)
+	}
+	resumed.opts.Seed = 2
+	if err := fillAccounts(resumed, 1000); err == nil {
+		t.Fatal("resumed a random filler fill with another -seed")
+	}
+	resumed.opts.Seed, resumed.opts.FillBatch = 1, 700
+	if err := fillAccounts(resumed, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(dump(whole), dump(resumed)) {
+		t.Error("the resumed fill differs from the uninterrupted one")
+	}
+}
+
+func TestReadWriteUpdatesWritesPerTxnAccounts(t *testing.T) {
+	o := testOptions(t)
+	o.WritesPerTxn = 5
+	bn := openTest(t, o, allTables)
+	if err := bn.ReadWrite(context.Background(), bn.NewRng(0)); err != nil {
+		t.Fatal(err)
+	}
+	balances, err := bn.verifyBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := bn.appliedDelta.Load()
+	if balances.Accounts != applied || balances.Tellers != applied || balances.Branches != applied || balances.HistoryDelta != applied {
+		t.Errorf("balances %+v after applying %d", balances, applied)
+	}
+	if n, err := countKeys(bn.db, historyPrefix); err != nil || n != 1 {
+		t.Errorf("%d history rows, want 1: %v", n, err)
+	}
+	changed := 0
+	err = bn.db.View(func(txn Txn) error {
+		return txn.Bucket(accountPrefix).ForEach(func(k, v []byte) error {
+			var acc Account
+			if err := bn.codec.Unmarshal(v, &acc); err != nil {
+				return err
+			}
+			changed += lo.Ternary(acc.Abalance != 0, 1, 0)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed < 2 || changed > 5 {
+		t.Errorf("%d accounts changed, want up to 5", changed)
+	}
+}
+
+// benchOptions are the options of a database of 10000 accounts for b,
+// logging only warnings like -quiet
+func benchOptions(b *testing.B) Options {
+	b.Helper()
+	old := slog.SetLogLoggerLevel(slog.LevelWarn)
+	b.Cleanup(func() { slog.SetLogLoggerLevel(old) })
+	o := testOptions(b)
+	o.AccountsPerScale, o.TellersPerScale, o.BranchesPerScale = 10000, 100, 10
+	return o
+}
+
+// benchmarkOp runs op b.N times from one goroutine
+func benchmarkOp(b *testing.B, bn *Bench, op OpFunc) {
+	rng := bn.NewRng(0)
+	b.ResetTimer()
+	for range b.N {
+		if err := op(bn, context.Background(), rng); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkOpParallel runs op b.N times across GOMAXPROCS goroutines, each
+// drawing from a stream of its own
+func benchmarkOpParallel(b *testing.B, bn *Bench, op OpFunc) {
+	var stream atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rng := bn.NewRng(stream.Add(1))
+		for pb.Next() {
+			if err := op(bn, context.Background(), rng); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkRead(b *testing.B) {
+	benchmarkOp(b, openTest(b, benchOptions(b), allTables), (*Bench).Read)
+}
+
+func BenchmarkReadParallel(b *testing.B) {
+	benchmarkOpParallel(b, openTest(b, benchOptions(b), allTables), (*Bench).Read)
+}
+
+func BenchmarkReadWrite(b *testing.B) {
+	benchmarkOp(b, openTest(b, benchOptions(b), allTables), (*Bench).ReadWrite)
+}
+
+func BenchmarkReadWriteParallel(b *testing.B) {
+	for _, batch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("batch=%t", batch), func(b *testing.B) {
+			o := benchOptions(b)
+			o.Batch = batch
+			benchmarkOpParallel(b, openTest(b, o, allTables), (*Bench).ReadWrite)
+		})
+	}
+}
+
+// BenchmarkFillTable reports the time to fill 10000 accounts into an empty
+// table, in -fill-batch transactions
+func BenchmarkFillTable(b *testing.B) {
+	const rows = 10000
+	bn := openTest(b, benchOptions(b), nil)
+	gen := func(it int, filler string) []byte { return bn.valueFor(Account{AID: it, Filler: filler}) }
+	b.ResetTimer()
+	for i := range b.N {
+		// a table of its own per iteration, the backends cannot drop one
+		table := []byte(fmt.Sprintf("fill%d:", i))
+		b.StopTimer()
+		err := bn.db.Update(func(txn Txn) error {
+			_, err := txn.CreateBucketIfNotExists(table)
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if n, err := bn.fillTable(table, rows, 0, gen); err != nil || n != rows {
+			b.Fatalf("filled %d rows, want %d: %v", n, rows, err)
+		}
+	}
+	b.ReportMetric(float64(rows)*float64(b.N)/b.Elapsed().Seconds(), "rows/s")
+}
+
+// BenchmarkWorkload runs b.N operations of each workload through Measure at
+// a concurrency of 4, like -nops, so ns/op is the inverse of the throughput.
+// The latency percentiles of the run are reported next to it.
+func BenchmarkWorkload(b *testing.B) {
+	for _, name := range []string{"tpcb", "point-read", "scan", "multiread", "append"} {
+		b.Run(name, func(b *testing.B) {
+			o := benchOptions(b)
+			o.BucketStats = false
+			wl, err := SelectWorkload(o, name)
+			if err != nil {
+				b.Fatal(err)
+			}
+			bn := openTest(b, o, o.Tables(wl))
+			b.ResetTimer()
+			r, err := bn.Measure(context.Background(), wl, RunOptions{Concurrency: 4, Nops: uint64(b.N)})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if r.Errors > 0 {
+				b.Fatalf("%d of %d operations failed", r.Errors, r.Iterations)
+			}
+			b.ReportMetric(float64(r.Latency.P50), "p50-ns")
+			b.ReportMetric(float64(r.Latency.P99), "p99-ns")
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package bench
+
+import (
+	"context"
+	"time"
+)
+
+// waitBurst sleeps through the idle part of the current cycle of the window
+// measured since started. It returns how far into its burst the caller goes
+// on, or false when ctx ends first.
+func (o Options) waitBurst(ctx context.Context, started time.Time) (time.Duration, bool) {
+	at := time.Since(started) % (o.BurstIdle + o.BurstLength)
+	if at >= o.BurstIdle {
+		return at - o.BurstIdle, true
+	}
+	t := time.NewTimer(o.BurstIdle - at)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return 0, false
+	case <-t.C:
+		return 0, true
+	}
+}
+
+// BurstSummary splits the latencies of -burst-idle runs into the cold
+// operations at the start of a burst, right after an idle period, and the
+// warm rest of it. BurstRate is the throughput while not idle.
+type BurstSummary struct {
+	Idle      time.Duration `json:"idle_ns"`
+	Length    time.Duration `json:"length_ns"`
+	ColdFor   time.Duration `json:"cold_ns"`
+	Bursts    int           `json:"bursts"`
+	BurstRate float64       `json:"burst_throughput"`
+	Cold      OpSummary     `json:"cold"`
+	Warm      OpSummary     `json:"warm"`
+}
+
+// burstSummary expects the samples of every worker with the cold ones at
+// index 0 and the warm ones at 1
+func (o Options) burstSummary(latencies [][2]Latencies, iterations uint64, elapsed time.Duration) *BurstSummary {
+	if !o.bursts() {
+		return nil
+	}
+	var cold, warm []Latencies
+	for _, l := range latencies {
+		cold = append(cold, l[0])
+		warm = append(warm, l[1])
+	}
+	cycle := o.BurstIdle + o.BurstLength
+	bursting := elapsed/cycle*o.BurstLength + max(0, elapsed%cycle-o.BurstIdle)
+	s := &BurstSummary{
+		Idle:    o.BurstIdle,
+		Length:  o.BurstLength,
+		ColdFor: o.BurstCold,
+		Bursts:  int(elapsed / cycle),
+		Cold:    summarizeOp("cold", mergeLatencies(cold)),
+		Warm:    summarizeOp("warm", mergeLatencies(warm)),
+	}
+	if elapsed%cycle > o.BurstIdle {
+		s.Bursts++
+	}
+	if bursting > 0 {
+		s.BurstRate = float64(iterations) / bursting.Seconds()
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+package bench
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// compactBatch bounds the deletes of one trim transaction, so a large
+// backlog is worked off in several transactions instead of one huge one
+const compactBatch = 10_000
+
+// historyCompactor is a probe that trims history during the measured
+// window, like a retention job running alongside traffic
+type historyCompactor struct {
+	bn      *Bench
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	trimmed uint64
+	runs    uint64
+}
+
+func (h *historyCompactor) Start() error {
+	h.stop = make(chan struct{})
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		ticker := time.NewTicker(h.bn.opts.CompactInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				n, err := h.trim()
+				if err != nil {
+					slog.Error("history compaction failed", "err", err)
+					continue
+				}
+				h.trimmed += n
+				h.runs++
+			}
+		}
+	}()
+	return nil
+}
+
+// trim deletes up to compactBatch of the oldest records beyond the retention
+func (h *historyCompactor) trim() (uint64, error) {
+	var n uint64
+	retention := h.bn.opts.HistoryRetention
+	err := h.bn.db.Update(func(txn Txn) error {
+		b := txn.Bucket(historyPrefix)
+		seq := h.bn.lastHistoryKey(b)
+		if seq <= uint64(retention) {
+			return nil
+		}
+		oldest := keyFor(int(seq) - retention)
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, oldest) <= 0 && len(keys) < compactBatch; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = uint64(len(keys))
+		return nil
+	})
+	return n, err
+}
+
+func (h *historyCompactor) Stop(r *Result) error {
+	close(h.stop)
+	h.wg.Wait()
+	r.Compaction = &Compaction{Runs: h.runs, Trimmed: h.trimmed}
+	return nil
+}
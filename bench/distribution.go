@@ -0,0 +1,65 @@
+package bench
+
+import "math/rand/v2"
+
+// Rng is a worker's random source, ID picks record ids following -distribution
+type Rng struct {
+	*rand.Rand
+	// zipf holds a zipfian source of skew per id range, nil under uniform
+	zipf map[int]*rand.Zipf
+	skew float64
+	// worker and workers place the owning worker among all of them, for OwnID
+	worker, workers int
+	// firstID is the first id returned by ID since it was set to -1, the key
+	// of the operation in -trace
+	firstID int
+	// walk is the next account of sequential-read
+	walk int
+}
+
+// NewRng returns the random source of stream, one of the streams seeded
+// from -seed. The runner gives worker i stream i.
+func (bn *Bench) NewRng(stream uint64) *Rng {
+	r := &Rng{Rand: rand.New(rand.NewPCG(bn.opts.Seed, stream))}
+	if bn.opts.Distribution == "zipfian" {
+		r.zipf, r.skew = make(map[int]*rand.Zipf), bn.opts.ZipfSkew
+	}
+	return r
+}
+
+// ID returns an id in [0, n), the hottest id under zipfian is 0
+func (r *Rng) ID(n int) int {
+	id := r.id(n)
+	r.noteID(id)
+	return id
+}
+
+// noteID records id as the key of the operation for -trace, unless one is
+// recorded already
+func (r *Rng) noteID(id int) {
+	if r.firstID < 0 {
+		r.firstID = id
+	}
+}
+
+func (r *Rng) id(n int) int {
+	if r.zipf == nil {
+		return r.IntN(n)
+	}
+	z, ok := r.zipf[n]
+	if !ok {
+		z = rand.NewZipf(r.Rand, r.skew, 1, uint64(n-1))
+		r.zipf[n] = z
+	}
+	return int(z.Uint64())
+}
+
+// OwnID picks an id in [0, n) that no other worker's OwnID returns, as long
+// as n is at least the number of workers, so that a worker can expect to read
+// back what it wrote
+func (r *Rng) OwnID(n int) int {
+	if r.workers <= 1 || n < r.workers {
+		return r.ID(n)
+	}
+	return r.ID((n-r.worker+r.workers-1)/r.workers)*r.workers + r.worker
+}
@@ -0,0 +1,126 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var codecs = map[string]Codec{
+	"json":   jsonCodec{},
+	"gob":    gobCodec{},
+	"binary": binaryCodec{},
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// gobCodec encodes every value on its own, so each one carries its type
+// description just like it would when stored individually by an application
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// binaryCodec is a fixed layout of little endian int64 fields followed by a
+// length prefixed filler
+type binaryCodec struct{}
+
+var errShortRecord = errors.New("short binary record")
+
+func appendInts(buf []byte, vals ...int64) []byte {
+	for _, v := range vals {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(v))
+	}
+	return buf
+}
+
+func appendFiller(buf []byte, filler string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(filler)))
+	return append(buf, filler...)
+}
+
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	switch r := v.(type) {
+	case Account:
+		return appendFiller(appendInts(make([]byte, 0, 24+1+len(r.Filler)), int64(r.AID), r.BID, r.Abalance), r.Filler), nil
+	case Teller:
+		return appendFiller(appendInts(make([]byte, 0, 24+1+len(r.Filler)), int64(r.TID), r.BID, r.Tbalance), r.Filler), nil
+	case Branche:
+		return appendFiller(appendInts(make([]byte, 0, 16+1+len(r.Filler)), int64(r.BID), r.Bbalance), r.Filler), nil
+	case History:
+		return appendFiller(appendInts(make([]byte, 0, 40+1+len(r.Filler)), r.TID, r.BID, r.AID, r.Delta, r.Mtime.UnixNano()), r.Filler), nil
+	default:
+		return nil, fmt.Errorf("binary encoding does not support %T", v)
+	}
+}
+
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) int() int64 {
+	if len(r.data) < 8 {
+		r.err = errShortRecord
+		return 0
+	}
+	v := int64(binary.LittleEndian.Uint64(r.data))
+	r.data = r.data[8:]
+	return v
+}
+
+func (r *binaryReader) filler() string {
+	n, size := binary.Uvarint(r.data)
+	if size <= 0 || uint64(len(r.data)-size) < n {
+		r.err = errShortRecord
+		return ""
+	}
+	s := string(r.data[size : size+int(n)])
+	r.data = r.data[size+int(n):]
+	return s
+}
+
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	r := &binaryReader{data: data}
+	switch rec := v.(type) {
+	case *Account:
+		rec.AID, rec.BID, rec.Abalance, rec.Filler = int(r.int()), r.int(), r.int(), r.filler()
+	case *Teller:
+		rec.TID, rec.BID, rec.Tbalance, rec.Filler = int(r.int()), r.int(), r.int(), r.filler()
+	case *Branche:
+		rec.BID, rec.Bbalance, rec.Filler = int(r.int()), r.int(), r.filler()
+	case *History:
+		rec.TID, rec.BID, rec.AID, rec.Delta = r.int(), r.int(), r.int(), r.int()
+		rec.Mtime = time.Unix(0, r.int())
+		rec.Filler = r.filler()
+	default:
+		return fmt.Errorf("binary encoding does not support %T", v)
+	}
+	return r.err
+}
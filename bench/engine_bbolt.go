@@ -0,0 +1,36 @@
+//go:build bbolt
+
+package bench
+
+import (
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+)
+
+const EngineModule = "go.etcd.io/bbolt"
+
+type (
+	boltDB        = bolt.DB
+	boltTx        = bolt.Tx
+	boltRawBucket = bolt.Bucket
+	boltOptions   = bolt.Options
+)
+
+func openEngine(path string, options *boltOptions) (*boltDB, error) {
+	return bolt.Open(path, 0600, options)
+}
+
+// setEngineOptions applies the options only bbolt has
+func setEngineOptions(options *boltOptions, o BoltOptions) error {
+	options.NoFreelistSync = o.NoFreelistSync
+	switch o.FreelistType {
+	case "":
+	case "array":
+		options.FreelistType = bolt.FreelistArrayType
+	case "hashmap":
+		options.FreelistType = bolt.FreelistMapType
+	default:
+		return fmt.Errorf("unknown freelist type %q", o.FreelistType)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build !bbolt
+
+package bench
+
+import (
+	"errors"
+	"github.com/boltdb/bolt"
+)
+
+const EngineModule = "github.com/boltdb/bolt"
+
+type (
+	boltDB        = bolt.DB
+	boltTx        = bolt.Tx
+	boltRawBucket = bolt.Bucket
+	boltOptions   = bolt.Options
+)
+
+func openEngine(path string, options *boltOptions) (*boltDB, error) {
+	return bolt.Open(path, 0600, options)
+}
+
+// setEngineOptions applies the options only bbolt has
+func setEngineOptions(options *boltOptions, o BoltOptions) error {
+	if o.NoFreelistSync || o.FreelistType != "" {
+		return errors.New("-no-freelist-sync and -freelist-type need bbolt, build with -tags bbolt")
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package bench
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"runtime"
+	"time"
+)
+
+// fillTable returns the number of rows it wrote. A partly filled table is
+// only resumed with the fill settings it was started with.
+func (bn *Bench) fillTable(prefix []byte, limit, size int, genfunc func(it int, filler string) []byte) (int, error) {
+	created, err := tableRows(bn.db, prefix, limit)
+	if err != nil {
+		return 0, err
+	}
+	if created == limit {
+		slog.Info("table already filled", "prefix", prefix, "limit", limit)
+		return 0, nil
+	}
+	existing := created
+	if err := checkFillParams(bn.db, prefix, bn.fillParamsOf(prefix, size), existing > 0); err != nil {
+		return 0, err
+	}
+	if existing > 0 {
+		slog.Info(fmt.Sprintf("resuming fill from %d", existing), "prefix", prefix, "limit", limit)
+	}
+	started := time.Now()
+	defer func() {
+		logFillRate(string(prefix), created-existing, time.Since(started))
+	}()
+
+	// batches are encoded in parallel but committed strictly in id order by
+	// this goroutine, so an interrupted fill leaves no holes to resume from
+	batchSize := bn.opts.FillBatch
+	pending := make(chan chan fillBatch, 2*runtime.GOMAXPROCS(0))
+	// stop ends the encoding early when a commit fails
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(pending)
+		for start := created; start < limit; start += batchSize {
+			encoded := make(chan fillBatch, 1)
+			select {
+			case pending <- encoded:
+			case <-stop:
+				return
+			}
+			go func() {
+				encoded <- bn.encodeBatch(start, min(batchSize, limit-start), size, genfunc)
+			}()
+		}
+	}()
+
+	for encoded := range pending {
+		batch := <-encoded
+		slog.Info("filling table", "prefix", prefix, "limit", limit, "created", created, "eta", fillETA(created-existing, limit-created, time.Since(started)))
+		err = bn.db.Update(func(txn Txn) error {
+			b := txn.Bucket(prefix)
+			for i, key := range batch.keys {
+				if err := b.Put(key, batch.values[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return created - existing, err
+		}
+		created += len(batch.keys)
+	}
+	return created - existing, nil
+}
+
+type fillBatch struct {
+	keys   [][]byte
+	values [][]byte
+}
+
+func (bn *Bench) encodeBatch(start, n, size int, genfunc func(it int, filler string) []byte) fillBatch {
+	batch := fillBatch{keys: make([][]byte, n), values: make([][]byte, n)}
+	filler := bn.fillerSource(start, size)
+	for i := range n {
+		batch.keys[i] = keyFor(start + i)
+		batch.values[i] = genfunc(start+i, filler())
+	}
+	return batch
+}
+
+// fillETA estimates the time left to write remaining rows from the rate
+// of the done rows so far
+func fillETA(done, remaining int, took time.Duration) string {
+	if done == 0 {
+		return "unknown"
+	}
+	return (time.Duration(float64(took) / float64(done) * float64(remaining))).Round(time.Second).String()
+}
+
+func logFillRate(table string, rows int, took time.Duration) {
+	slog.Info("fill rate", "table", table, "rows", rows, "took", took.Round(time.Millisecond),
+		"rows/sec", fmt.Sprintf("%0.1f", float64(rows)/took.Seconds()))
+}
+
+// Fill fills the tables among tables that have a fixed size up to the
+// scale, history only with -prefill-history as it is otherwise only ever
+// written by the workloads. The tables must exist.
+func (bn *Bench) Fill(tables [][]byte) error {
+	accountsToCreate, tellersToCreate, branchesToCreate := bn.opts.tableSizes()
+	started := time.Now()
+	rows := 0
+	defer func() {
+		if rows > 0 {
+			logFillRate("total", rows, time.Since(started))
+		}
+	}()
+
+	for _, t := range []struct {
+		prefix []byte
+		limit  int
+		size   int
+		gen    func(it int, filler string) []byte
+	}{
+		{accountPrefix, accountsToCreate, bn.opts.fillSizeOf(bn.opts.FillSizeAccounts), func(it int, filler string) []byte {
+			return bn.valueFor(Account{AID: it, Filler: filler})
+		}},
+		{tellerPrefix, tellersToCreate, bn.opts.fillSizeOf(bn.opts.FillSizeTellers), func(it int, filler string) []byte {
+			return bn.valueFor(Teller{TID: it, Filler: filler})
+		}},
+		{branchPrefix, branchesToCreate, bn.opts.fillSizeOf(bn.opts.FillSizeBranches), func(it int, filler string) []byte {
+			return bn.valueFor(Branche{BID: it, Filler: filler})
+		}},
+	} {
+		if !hasTable(tables, t.prefix) {
+			continue
+		}
+		n, err := bn.fillTable(t.prefix, t.limit, t.size, t.gen)
+		rows += n
+		if err != nil {
+			return err
+		}
+	}
+
+	if hasTable(tables, historyPrefix) && bn.opts.PrefillHistory > 0 {
+		n, err := bn.fillHistory(accountsToCreate, tellersToCreate, branchesToCreate)
+		rows += n
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := bn.fillSchema(tables)
+	rows += n
+	return err
+}
+
+// fillHistory creates -prefill-history records of random accounts, tellers
+// and branches at keys from 0 and moves the sequence up to the last one.
+// Deltas come in pairs cancelling each other, so that the history still sums
+// to the balances of the freshly filled tables.
+func (bn *Bench) fillHistory(accounts, tellers, branches int) (int, error) {
+	mtime := time.Now()
+	prefill, seed := bn.opts.PrefillHistory, bn.opts.Seed
+	rows, err := bn.fillTable(historyPrefix, prefill, bn.opts.fillSizeOf(bn.opts.FillSizeHistory), func(it int, filler string) []byte {
+		rng := Rng{Rand: rand.New(rand.NewPCG(seed, uint64(it)))}
+		pair := Rng{Rand: rand.New(rand.NewPCG(seed+1, uint64(it/2)))}
+		delta := bn.randomDelta(&pair)
+		switch {
+		case it%2 == 1:
+			delta = -delta
+		case it+1 == prefill:
+			// the last record of an odd count has no pair
+			delta = 0
+		}
+		return bn.valueFor(History{
+			AID:    int64(rng.IntN(accounts)),
+			TID:    int64(rng.IntN(tellers)),
+			BID:    int64(rng.IntN(branches)),
+			Delta:  delta,
+			Mtime:  mtime,
+			Filler: filler,
+		})
+	})
+	if err != nil {
+		return rows, err
+	}
+	return rows, bn.db.Update(func(txn Txn) error {
+		b := txn.Bucket(historyPrefix)
+		if last := uint64(prefill - 1); b.Sequence() < last {
+			return b.SetSequence(last)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,110 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/samber/lo"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+)
+
+const fillerAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// newFiller returns size bytes of filler. Random filler is drawn from an
+// alphanumeric alphabet so that it stays valid UTF-8 and json stores it
+// without escaping.
+func (bn *Bench) newFiller(rng *Rng, size int) string {
+	switch bn.opts.FillerMode {
+	case "zeros":
+		return strings.Repeat("\x00", size)
+	case "random":
+		buf := make([]byte, size)
+		for i := range buf {
+			buf[i] = fillerAlphabet[rng.IntN(len(fillerAlphabet))]
+		}
+		return string(buf)
+	default:
+		return strings.Repeat("x", size)
+	}
+}
+
+// fillerSource generates size bytes of filler for the rows starting at id
+// start. The random source is reseeded from the id of every row rather than
+// derived from the goroutine encoding it or the batch, which keeps the fill
+// deterministic for a given -seed even though batches are encoded in
+// parallel, and a resumed fill identical to an uninterrupted one whatever
+// -fill-batch it runs with.
+func (bn *Bench) fillerSource(start, size int) func() string {
+	if bn.opts.FillerMode != "random" {
+		filler := bn.newFiller(nil, size)
+		return func() string { return filler }
+	}
+	seed := bn.opts.Seed
+	pcg := rand.NewPCG(seed, uint64(start))
+	rng := &Rng{Rand: rand.New(pcg)}
+	id := start
+	return func() string {
+		pcg.Seed(seed, uint64(id))
+		id++
+		return bn.newFiller(rng, size)
+	}
+}
+
+// fillMetaPrefix is the bucket recording the fillParams of every table, keyed
+// by its name
+var fillMetaPrefix = []byte("fill-meta:")
+
+// fillParams are the settings the rows of a table are generated from. Seed
+// is only set for tables whose rows depend on it, those of random filler and
+// prefilled history.
+type fillParams struct {
+	Seed   uint64 `json:"seed,omitempty"`
+	Filler string `json:"filler"`
+	Size   int    `json:"size"`
+}
+
+func (bn *Bench) fillParamsOf(prefix []byte, size int) fillParams {
+	p := fillParams{Filler: bn.opts.FillerMode, Size: size}
+	if bn.opts.FillerMode == "random" || bytes.Equal(prefix, historyPrefix) {
+		p.Seed = bn.opts.Seed
+	}
+	return p
+}
+
+func (p fillParams) String() string {
+	return fmt.Sprintf("-filler-mode %s, filler size %d", p.Filler, p.Size) + lo.Ternary(p.Seed != 0, fmt.Sprintf(" and -seed %d", p.Seed), "")
+}
+
+// checkFillParams records the fill settings of table when its fill starts,
+// and when resuming refuses settings other than the recorded ones, which
+// would not generate the rows an uninterrupted fill would have
+func checkFillParams(db Backend, table []byte, params fillParams, resuming bool) error {
+	return db.Update(func(txn Txn) error {
+		meta, err := txn.CreateBucketIfNotExists(fillMetaPrefix)
+		if err != nil {
+			return err
+		}
+		raw := meta.Get(table)
+		if !resuming || raw == nil {
+			if resuming {
+				slog.Warn("the fill settings of the resumed table were not recorded, it may not match an uninterrupted fill", "prefix", table)
+			}
+			data, err := json.Marshal(params)
+			if err != nil {
+				return err
+			}
+			return meta.Put(table, data)
+		}
+		var recorded fillParams
+		if err := json.Unmarshal(raw, &recorded); err != nil {
+			return fmt.Errorf("fill settings of %s: %w", strings.TrimSuffix(string(table), ":"), err)
+		}
+		if recorded != params {
+			return fmt.Errorf("%s was partly filled with %s, resume with the same settings or fill another database",
+				strings.TrimSuffix(string(table), ":"), recorded)
+		}
+		return nil
+	})
+}
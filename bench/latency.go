@@ -0,0 +1,187 @@
+package bench
+
+import (
+	"math"
+	"math/bits"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+type Latencies []time.Duration
+
+func mergeLatencies(parts []Latencies) Latencies {
+	total := 0
+	for _, p := range parts {
+		total += len(p)
+	}
+	rv := make(Latencies, 0, total)
+	for _, p := range parts {
+		rv = append(rv, p...)
+	}
+	slices.Sort(rv)
+	return rv
+}
+
+// Percentile expects sorted latencies
+func (l Latencies) Percentile(p float64) time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	idx := int(float64(len(l))*p/100+0.5) - 1
+	idx = max(0, min(idx, len(l)-1))
+	return l[idx]
+}
+
+func (l Latencies) Mean() time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range l {
+		sum += d
+	}
+	return sum / time.Duration(len(l))
+}
+
+func (l Latencies) Min() time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	return l[0]
+}
+
+func (l Latencies) StdDev() time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	mean := float64(l.Mean())
+	var sum float64
+	for _, d := range l {
+		diff := float64(d) - mean
+		sum += diff * diff
+	}
+	return time.Duration(math.Sqrt(sum / float64(len(l))))
+}
+
+func (l Latencies) Max() time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	return l[len(l)-1]
+}
+
+// WindowSummary is the latency of the operations started in one
+// -latency-window, Start is its offset from the start of measurement
+type WindowSummary struct {
+	Start      time.Duration `json:"start_ns"`
+	Count      int           `json:"count"`
+	Throughput float64       `json:"throughput"`
+	P50        time.Duration `json:"p50_ns"`
+	P99        time.Duration `json:"p99_ns"`
+	Max        time.Duration `json:"max_ns"`
+}
+
+// windowSummaries splits the samples of every worker at its marks, see
+// run, and summarizes each window of length window over all workers. The
+// last window is cut short by the end of the run.
+func windowSummaries(latencies [][2]Latencies, marks [][2][]int, window, elapsed time.Duration) []WindowSummary {
+	if window <= 0 {
+		return nil
+	}
+	var windows []WindowSummary
+	for w := 0; ; w++ {
+		var parts []Latencies
+		for worker, l := range latencies {
+			for kind, m := range marks[worker] {
+				if w >= len(m) {
+					continue
+				}
+				end := len(l[kind])
+				if w+1 < len(m) {
+					end = m[w+1]
+				}
+				parts = append(parts, l[kind][m[w]:end])
+			}
+		}
+		if parts == nil {
+			return windows
+		}
+		merged := mergeLatencies(parts)
+		start := time.Duration(w) * window
+		length := min(window, elapsed-start)
+		windows = append(windows, WindowSummary{
+			Start:      start,
+			Count:      len(merged),
+			Throughput: float64(len(merged)) / length.Seconds(),
+			P50:        merged.Percentile(50),
+			P99:        merged.Percentile(99),
+			Max:        merged.Max(),
+		})
+	}
+}
+
+// Histogram counts latencies in power-of-two microsecond buckets: bucket 0
+// holds everything under 1us, bucket i holds [2^(i-1), 2^i) us. Updates are
+// atomic so it can be read while a worker is recording into it.
+type Histogram struct {
+	counts [64]atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	h.counts[bits.Len64(uint64(max(d.Microseconds(), 0)))].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *Histogram) Add(other *Histogram) {
+	for i := range other.counts {
+		h.counts[i].Add(other.counts[i].Load())
+	}
+	h.sum.Add(other.sum.Load())
+}
+
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(h.sum.Load())
+}
+
+// Counts returns the count of every bucket, see Histogram
+func (h *Histogram) Counts() []uint64 {
+	counts := make([]uint64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+	}
+	return counts
+}
+
+type HistogramBucket struct {
+	FromUs int64  `json:"from_us"`
+	ToUs   int64  `json:"to_us"`
+	Count  uint64 `json:"count"`
+}
+
+// Buckets returns the range of buckets between the first and last non-empty
+func (h *Histogram) Buckets() []HistogramBucket {
+	first, last := -1, -1
+	for i := range h.counts {
+		if h.counts[i].Load() == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+	}
+	if first < 0 {
+		return nil
+	}
+	rv := make([]HistogramBucket, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		b := HistogramBucket{ToUs: 1 << i, Count: h.counts[i].Load()}
+		if i > 0 {
+			b.FromUs = 1 << (i - 1)
+		}
+		rv = append(rv, b)
+	}
+	return rv
+}
@@ -0,0 +1,66 @@
+package bench
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// longReaderSet is a probe keeping -long-readers read transactions open
+// during the measured window. Pages freed by writers stay pending while a
+// read transaction that could still see them is open, so writers allocate new
+// pages and the file grows. The readers are staggered, so that some
+// transaction is always open. A writer remapping the grown file waits for the
+// open ones to finish, which bounds how long the run can overrun its end.
+type longReaderSet struct {
+	bn   *Bench
+	stop chan struct{}
+	wg   sync.WaitGroup
+	txns atomic.Uint64
+}
+
+func (l *longReaderSet) hold(offset time.Duration) {
+	defer l.wg.Done()
+	select {
+	case <-l.stop:
+		return
+	case <-time.After(offset):
+	}
+	for {
+		err := l.bn.db.View(func(txn Txn) error {
+			select {
+			case <-l.stop:
+			case <-time.After(l.bn.opts.LongReaderHold):
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Error("long read transaction failed", "err", err)
+			return
+		}
+		l.txns.Add(1)
+		select {
+		case <-l.stop:
+			return
+		default:
+		}
+	}
+}
+
+func (l *longReaderSet) Start() error {
+	l.stop = make(chan struct{})
+	readers, hold := l.bn.opts.LongReaders, l.bn.opts.LongReaderHold
+	for i := 0; i < readers; i++ {
+		l.wg.Add(1)
+		go l.hold(hold * time.Duration(i) / time.Duration(readers))
+	}
+	return nil
+}
+
+func (l *longReaderSet) Stop(r *Result) error {
+	close(l.stop)
+	l.wg.Wait()
+	r.LongReaders = &LongReaders{Readers: l.bn.opts.LongReaders, Hold: l.bn.opts.LongReaderHold, Txns: l.txns.Load()}
+	return nil
+}
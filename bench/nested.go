@@ -0,0 +1,272 @@
+package bench
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// nestedBackend stores accounts in per-branch buckets nested in the accounts
+// bucket, keyed by the branch id. The other tables stay flat. Account ids of
+// a branch are contiguous, so walking the branch buckets in order keeps the
+// accounts in id order. perBranch is the number of accounts per branch.
+type nestedBackend struct {
+	Backend
+	perBranch int
+}
+
+type nestedStatsBackend struct {
+	nestedBackend
+	StatsBackend
+}
+
+func newNestedBackend(db Backend, perBranch int) Backend {
+	n := nestedBackend{Backend: db, perBranch: perBranch}
+	if sb, ok := db.(StatsBackend); ok {
+		return nestedStatsBackend{nestedBackend: n, StatsBackend: sb}
+	}
+	return n
+}
+
+// checkLayout fails when the accounts of db are stored in the other layout
+// than nested asks for. It must be given the backend before it is wrapped by
+// newNestedBackend. Nested buckets show up as keys without a value.
+func checkLayout(db Backend, nested bool) error {
+	return db.View(func(txn Txn) error {
+		b := txn.Bucket(accountPrefix)
+		if b == nil {
+			return nil
+		}
+		k, v := b.Cursor().First()
+		if k == nil || (v == nil) == nested {
+			return nil
+		}
+		if nested {
+			return errors.New("accounts are stored flat, run without -nested or fill another database")
+		}
+		return errors.New("accounts are stored in nested buckets, run with -nested or fill another database")
+	})
+}
+
+func (n nestedBackend) Update(fn func(txn Txn) error) error {
+	return n.Backend.Update(func(txn Txn) error { return fn(nestedTxn{txn, n.perBranch}) })
+}
+
+func (n nestedBackend) View(fn func(txn Txn) error) error {
+	return n.Backend.View(func(txn Txn) error { return fn(nestedTxn{txn, n.perBranch}) })
+}
+
+func (n nestedBackend) Batch(fn func(txn Txn) error) error {
+	return n.Backend.Batch(func(txn Txn) error { return fn(nestedTxn{txn, n.perBranch}) })
+}
+
+type nestedTxn struct {
+	Txn
+	perBranch int
+}
+
+func (t nestedTxn) Bucket(name []byte) Bucket {
+	b := t.Txn.Bucket(name)
+	if b == nil || !bytes.Equal(name, accountPrefix) {
+		return b
+	}
+	return nestedBucket{b, t.perBranch}
+}
+
+func (t nestedTxn) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.Txn.CreateBucketIfNotExists(name)
+	if err != nil || !bytes.Equal(name, accountPrefix) {
+		return b, err
+	}
+	return nestedBucket{b, t.perBranch}, nil
+}
+
+// nestedBucket routes every account to the bucket of its branch, which is
+// created on the first Put. The sequence is kept in the parent.
+type nestedBucket struct {
+	parent    Bucket
+	perBranch int
+}
+
+// branchKey groups the accounts into contiguous ranges of perBranch, the
+// -branches-per-scale ranges per scale unit, so a database filled with
+// -nested must be run with the ratios it was filled with
+func branchKey(key []byte, perBranch int) ([]byte, error) {
+	id, err := idFor(key)
+	if err != nil {
+		return nil, err
+	}
+	return keyFor(id / perBranch), nil
+}
+
+func (b nestedBucket) Get(key []byte) []byte {
+	bk, err := branchKey(key, b.perBranch)
+	if err != nil {
+		return nil
+	}
+	branch := b.parent.Bucket(bk)
+	if branch == nil {
+		return nil
+	}
+	return branch.Get(key)
+}
+
+func (b nestedBucket) Put(key []byte, value []byte) error {
+	bk, err := branchKey(key, b.perBranch)
+	if err != nil {
+		return err
+	}
+	branch := b.parent.Bucket(bk)
+	if branch == nil {
+		if branch, err = b.parent.CreateBucketIfNotExists(bk); err != nil {
+			return err
+		}
+	}
+	return branch.Put(key, value)
+}
+
+func (b nestedBucket) Delete(key []byte) error {
+	bk, err := branchKey(key, b.perBranch)
+	if err != nil {
+		return err
+	}
+	branch := b.parent.Bucket(bk)
+	if branch == nil {
+		return nil
+	}
+	return branch.Delete(key)
+}
+
+func (b nestedBucket) NextSequence() (uint64, error) {
+	return b.parent.NextSequence()
+}
+
+func (b nestedBucket) Sequence() uint64 {
+	return b.parent.Sequence()
+}
+
+func (b nestedBucket) SetSequence(v uint64) error {
+	return b.parent.SetSequence(v)
+}
+
+func (b nestedBucket) ForEach(fn func(k, v []byte) error) error {
+	return b.parent.ForEach(func(bk, _ []byte) error {
+		branch := b.parent.Bucket(bk)
+		if branch == nil {
+			return fmt.Errorf("%s%x is not a nested bucket", accountPrefix, bk)
+		}
+		return branch.ForEach(fn)
+	})
+}
+
+func (b nestedBucket) Cursor() Cursor {
+	return &chainCursor{parent: b.parent, branches: b.parent.Cursor(), perBranch: b.perBranch}
+}
+
+func (b nestedBucket) Bucket(name []byte) Bucket {
+	return nil
+}
+
+func (b nestedBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	return nil, errors.New("nested accounts do not support further nesting")
+}
+
+// chainCursor walks the branch buckets one after another
+type chainCursor struct {
+	parent    Bucket
+	branches  Cursor
+	cur       Cursor
+	perBranch int
+}
+
+// enter positions cur in the branch bucket bk, returning false past the
+// last branch
+func (c *chainCursor) enter(bk []byte) bool {
+	c.cur = nil
+	if bk == nil {
+		return false
+	}
+	if branch := c.parent.Bucket(bk); branch != nil {
+		c.cur = branch.Cursor()
+	}
+	return true
+}
+
+// forward skips ahead to the next branch while the current one is exhausted
+func (c *chainCursor) forward(k, v []byte) ([]byte, []byte) {
+	for k == nil {
+		if !c.enter(keyOf(c.branches.Next())) {
+			return nil, nil
+		}
+		if c.cur != nil {
+			k, v = c.cur.First()
+		}
+	}
+	return k, v
+}
+
+// backward skips back to the previous branch while the current one is
+// exhausted
+func (c *chainCursor) backward(k, v []byte) ([]byte, []byte) {
+	for k == nil {
+		if !c.enter(keyOf(c.branches.Prev())) {
+			return nil, nil
+		}
+		if c.cur != nil {
+			k, v = c.cur.Last()
+		}
+	}
+	return k, v
+}
+
+func (c *chainCursor) First() ([]byte, []byte) {
+	if !c.enter(keyOf(c.branches.First())) || c.cur == nil {
+		return c.forward(nil, nil)
+	}
+	return c.forward(c.cur.First())
+}
+
+func (c *chainCursor) Last() ([]byte, []byte) {
+	if !c.enter(keyOf(c.branches.Last())) || c.cur == nil {
+		return c.backward(nil, nil)
+	}
+	return c.backward(c.cur.Last())
+}
+
+func (c *chainCursor) Next() ([]byte, []byte) {
+	if c.cur == nil {
+		return nil, nil
+	}
+	return c.forward(c.cur.Next())
+}
+
+func (c *chainCursor) Prev() ([]byte, []byte) {
+	if c.cur == nil {
+		return nil, nil
+	}
+	return c.backward(c.cur.Prev())
+}
+
+func (c *chainCursor) Seek(seek []byte) ([]byte, []byte) {
+	bk, err := branchKey(seek, c.perBranch)
+	if err != nil {
+		return nil, nil
+	}
+	found := keyOf(c.branches.Seek(bk))
+	if !c.enter(found) {
+		return nil, nil
+	}
+	if c.cur == nil {
+		return c.forward(nil, nil)
+	}
+	if !bytes.Equal(found, bk) {
+		// the branch of seek does not exist, start at the next one
+		return c.forward(c.cur.First())
+	}
+	return c.forward(c.cur.Seek(seek))
+}
+
+// keyOf returns the key of a cursor position
+func keyOf(k, _ []byte) []byte {
+	return k
+}
@@ -0,0 +1,241 @@
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Options are the settings of a Bench: the database, the dataset it is
+// filled with, the workloads run against it and what is measured around
+// them. Start from DefaultOptions, the zero value fills nothing. Errors name
+// an option by the boltbench flag setting it.
+type Options struct {
+	// DBPath is the database file, opened with the storage Backend
+	DBPath  string
+	Backend string
+	// Init creates the tables and fills them up to Scale, skipping the rows
+	// already there, so a pre-built database is never rewritten. Without it
+	// the database is used as is, Scale must then not exceed the scale it was
+	// filled with, since it bounds the ids the workloads pick.
+	Init bool
+	Bolt BoltOptions
+	// SyncMode is the commit durability: always fsyncs every commit, none
+	// never does, batched commits without fsync and syncs every SyncInterval;
+	// empty follows Bolt.NoSync
+	SyncMode     string
+	SyncInterval time.Duration
+	// Shards splits every table into this many buckets with keys routed by
+	// hash, Nested stores accounts in one bucket per branch
+	Shards int
+	Nested bool
+	// Encoding is the value encoding: json, gob or binary
+	Encoding string
+
+	// each Scale unit adds AccountsPerScale accounts, TellersPerScale
+	// tellers and BranchesPerScale branches
+	Scale            int
+	AccountsPerScale int
+	TellersPerScale  int
+	BranchesPerScale int
+	// FillBatch is the number of rows written per fill transaction
+	FillBatch int
+	// FillerMode is the filler content: zeros, repeat or random. FillSize is
+	// its size in bytes for the record types whose own size is -1.
+	FillerMode       string
+	FillSize         int
+	FillSizeAccounts int
+	FillSizeTellers  int
+	FillSizeBranches int
+	FillSizeHistory  int
+	// PrefillHistory fills history with this many records
+	PrefillHistory int
+	// Seed seeds the fill and workload random sources, 0 picks a random one
+	Seed uint64
+	// Schema describes the custom buckets of the schema workload
+	Schema []SchemaBucket
+
+	// RWMode makes tpcb write, ReadPct mixes reads into it when >= 0
+	RWMode       bool
+	ReadPct      int
+	FullRead     bool
+	NoHistory    bool
+	WritesPerTxn int
+	// DeltaMin and DeltaMax bound the balance changes, inclusive
+	DeltaMin         int64
+	DeltaMax         int64
+	ScanLen          int
+	MultireadKeys    int
+	ChurnWindow      int
+	DashboardHistory int
+	DashboardDecode  bool
+	// Distribution picks the keys: uniform or zipfian with ZipfSkew
+	Distribution string
+	ZipfSkew     float64
+	// HistoryKey allocates history keys: sequence uses the bucket
+	// NextSequence, counter an in memory atomic counter
+	HistoryKey string
+	// WritePool is the number of workers doing only writes, the others then
+	// do only reads; 0 mixes reads and writes in every worker
+	WritePool int
+	// MaxRetries bounds the retries of a failed write, the backoff between
+	// them starts at RetryBackoff and doubles up to RetryBackoffMax
+	MaxRetries      int
+	RetryBackoff    time.Duration
+	RetryBackoffMax time.Duration
+	// Batch commits writes through Backend.Batch
+	Batch bool
+
+	// SampleRate is the fraction of operations timed for the latencies,
+	// LatencyWindow the length of the windows they are also summarized in
+	SampleRate    float64
+	LatencyWindow time.Duration
+	TimeBreakdown bool
+	// Trace records every measured operation when set
+	Trace       *Tracer
+	BucketStats bool
+	// Verify checks the balances and CheckHistory the history rows after
+	// every measured window
+	Verify       bool
+	CheckHistory bool
+	// CompactHistory trims history to HistoryRetention records every
+	// CompactInterval during the measured window
+	CompactHistory   bool
+	HistoryRetention int
+	CompactInterval  time.Duration
+	// LongReaders read transactions are held open for LongReaderHold each
+	// during the measured window
+	LongReaders    int
+	LongReaderHold time.Duration
+	// BurstIdle alternates idle periods with BurstLength of load, the
+	// operations within BurstCold of the start of a burst count as cold
+	BurstIdle   time.Duration
+	BurstLength time.Duration
+	BurstCold   time.Duration
+}
+
+// BoltOptions are passed to the bolt engine, NoFreelistSync and
+// FreelistType need bbolt
+type BoltOptions struct {
+	NoSync         bool
+	NoGrowSync     bool
+	NoFreelistSync bool
+	FreelistType   string
+	InitialMmap    int
+	MmapFlags      int
+}
+
+// DefaultOptions returns the defaults of the boltbench flags
+func DefaultOptions() Options {
+	return Options{
+		DBPath:           "my.db",
+		Backend:          "bolt",
+		Init:             true,
+		SyncInterval:     100 * time.Millisecond,
+		Shards:           1,
+		Encoding:         "json",
+		Scale:            1000,
+		AccountsPerScale: 100_000,
+		TellersPerScale:  10,
+		BranchesPerScale: 1,
+		FillBatch:        1000,
+		FillerMode:       "repeat",
+		FillSizeAccounts: -1,
+		FillSizeTellers:  -1,
+		FillSizeBranches: -1,
+		FillSizeHistory:  -1,
+		RWMode:           true,
+		ReadPct:          -1,
+		WritesPerTxn:     1,
+		DeltaMin:         -5000,
+		DeltaMax:         4999,
+		ScanLen:          100,
+		MultireadKeys:    10,
+		ChurnWindow:      100_000,
+		DashboardHistory: 10,
+		DashboardDecode:  true,
+		Distribution:     "uniform",
+		ZipfSkew:         1.1,
+		HistoryKey:       "sequence",
+		MaxRetries:       10,
+		RetryBackoff:     time.Millisecond,
+		RetryBackoffMax:  100 * time.Millisecond,
+		SampleRate:       1,
+		LatencyWindow:    10 * time.Second,
+		BucketStats:      true,
+		HistoryRetention: 100_000,
+		CompactInterval:  time.Second,
+		LongReaderHold:   time.Second,
+		BurstLength:      time.Second,
+		BurstCold:        10 * time.Millisecond,
+	}
+}
+
+// Check rejects the names no option accepts
+func (o Options) Check() error {
+	switch o.Distribution {
+	case "uniform":
+	case "zipfian":
+		if o.ZipfSkew <= 1 {
+			return fmt.Errorf("-zipf-skew must be > 1, got %v", o.ZipfSkew)
+		}
+	default:
+		return fmt.Errorf("unknown distribution %q", o.Distribution)
+	}
+	switch o.FillerMode {
+	case "zeros", "repeat", "random":
+	default:
+		return fmt.Errorf("unknown filler mode %q", o.FillerMode)
+	}
+	switch o.HistoryKey {
+	case "sequence", "counter":
+	default:
+		return fmt.Errorf("unknown history key mode %q", o.HistoryKey)
+	}
+	switch o.SyncMode {
+	case "", "always", "none", "batched":
+	default:
+		return fmt.Errorf("unknown sync mode %q", o.SyncMode)
+	}
+	if o.SyncMode != "" && o.Bolt.NoSync {
+		return errors.New("-nosync and -sync-mode cannot be combined, use -sync-mode none")
+	}
+	if _, ok := codecs[o.Encoding]; !ok {
+		return fmt.Errorf("unknown encoding %q", o.Encoding)
+	}
+	return nil
+}
+
+// EffectiveSyncMode resolves an empty SyncMode from Bolt.NoSync
+func (o Options) EffectiveSyncMode() string {
+	if o.SyncMode != "" {
+		return o.SyncMode
+	}
+	if o.Bolt.NoSync {
+		return "none"
+	}
+	return "always"
+}
+
+// fillSizeOf returns the per record type size, falling back to FillSize
+func (o Options) fillSizeOf(size int) int {
+	if size >= 0 {
+		return size
+	}
+	return o.FillSize
+}
+
+func (o Options) tableSizes() (accounts, tellers, branches int) {
+	return o.Scale * o.AccountsPerScale, o.Scale * o.TellersPerScale, o.Scale * o.BranchesPerScale
+}
+
+// pools reports whether WritePool splits the workers into a write and a read
+// pool instead of each mixing reads and writes
+func (o Options) pools() bool {
+	return o.WritePool > 0
+}
+
+// bursts reports whether BurstIdle alternates idle and burst periods
+func (o Options) bursts() bool {
+	return o.BurstIdle > 0
+}
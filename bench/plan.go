@@ -0,0 +1,85 @@
+package bench
+
+import (
+	"github.com/samber/lo"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// bolt stores each key/value with a 16 byte leaf element header behind a 16
+// byte page header, and splits pages at its default 50% fill percent, so
+// sequentially filled pages end up about half full
+const (
+	PageHeaderSize  = 16
+	leafElementSize = 16
+	FillFactor      = 0.5
+)
+
+func (bn *Bench) estimateRowSize(id int, val interface{}) int {
+	return leafElementSize + len(keyFor(id)) + len(bn.valueFor(val))
+}
+
+// PlanRow is a filled table with its estimated row size
+type PlanRow struct {
+	Table []byte
+	Count int
+	Size  int
+}
+
+func (r PlanRow) Name() string {
+	return strings.TrimSuffix(string(r.Table), ":")
+}
+
+// Overflows reports whether a row does not fit a page of pageSize on its
+// own, bolt then stores every such row in overflow pages
+func (r PlanRow) Overflows(pageSize int) bool {
+	return PageHeaderSize+r.Size > pageSize
+}
+
+// RowsPerPage is the number of rows a page of pageSize holds once filled, at
+// least one as overflowing rows get their own
+func (r PlanRow) RowsPerPage(pageSize int) int {
+	return max(1, int(float64(pageSize-PageHeaderSize)*FillFactor)/r.Size)
+}
+
+// Plan estimates the tables filled by Init, the custom ones of schema
+// instead of the TPC-B tables when it is given
+func Plan(o Options, schema []SchemaBucket) []PlanRow {
+	if schema != nil {
+		var rows []PlanRow
+		for _, b := range schema {
+			rows = append(rows, PlanRow{[]byte(b.Name), b.Keys, leafElementSize + len(keyFor(b.Keys-1)) + b.ValueSize})
+		}
+		return rows
+	}
+	bn := &Bench{opts: o, codec: codecs[o.Encoding]}
+	accounts, tellers, branches := o.tableSizes()
+	filler := func(size int) string { return bn.fillerSource(0, o.fillSizeOf(size))() }
+	rows := []PlanRow{
+		{accountPrefix, accounts, bn.estimateRowSize(accounts-1, Account{AID: accounts - 1, Filler: filler(o.FillSizeAccounts)})},
+		{tellerPrefix, tellers, bn.estimateRowSize(tellers-1, Teller{TID: tellers - 1, Filler: filler(o.FillSizeTellers)})},
+		{branchPrefix, branches, bn.estimateRowSize(branches-1, Branche{BID: branches - 1, Filler: filler(o.FillSizeBranches)})},
+	}
+	if n := o.PrefillHistory; n > 0 {
+		rows = append(rows, PlanRow{historyPrefix, n, bn.estimateRowSize(n-1, History{AID: int64(accounts - 1), TID: int64(tellers - 1), BID: int64(branches - 1),
+			Delta: o.DeltaMin, Mtime: time.Now(), Filler: filler(o.FillSizeHistory)})})
+	}
+	return rows
+}
+
+// logPageFit logs how the rows of the filled tables among tables fit the
+// pages of the database, to pick value sizes that do or do not overflow
+func logPageFit(o Options, pageSize int, tables [][]byte) {
+	rows := Plan(o, nil)
+	if o.Schema != nil {
+		rows = append(rows, Plan(o, o.Schema)...)
+	}
+	for _, r := range rows {
+		if !hasTable(tables, r.Table) {
+			continue
+		}
+		slog.Info("page fit", "table", r.Name(), "page_size", pageSize, "row_size", r.Size,
+			"rows_per_page", r.RowsPerPage(pageSize), "overflowing_rows", lo.Ternary(r.Overflows(pageSize), r.Count, 0))
+	}
+}
@@ -0,0 +1,48 @@
+package bench
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// pacer spreads operations evenly at -rate across all workers: the n-th
+// operation is due n intervals after the pacer was started. When the system
+// falls behind, due operations are issued back to back until it catches up,
+// so the aggregate rate is kept. Latency is measured from the due time, so
+// the time an operation waited in the backlog counts instead of being
+// omitted.
+type pacer struct {
+	started  time.Time
+	interval time.Duration
+	issued   atomic.Int64
+}
+
+func newPacer(rate float64) *pacer {
+	if rate <= 0 {
+		return nil
+	}
+	return &pacer{started: time.Now(), interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until the next operation is due and returns its due time, or
+// false if ctx was cancelled first. A nil pacer never waits and returns the
+// zero time.
+func (p *pacer) wait(ctx context.Context) (time.Time, bool) {
+	if p == nil {
+		return time.Time{}, true
+	}
+	due := p.started.Add(time.Duration(p.issued.Add(1)-1) * p.interval)
+	d := time.Until(due)
+	if d <= 0 {
+		return due, true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return due, true
+	case <-ctx.Done():
+		return time.Time{}, false
+	}
+}
@@ -0,0 +1,226 @@
+package bench
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type LatencySummary struct {
+	Min    time.Duration `json:"min_ns"`
+	Mean   time.Duration `json:"mean_ns"`
+	StdDev time.Duration `json:"stddev_ns"`
+	P50    time.Duration `json:"p50_ns"`
+	P95    time.Duration `json:"p95_ns"`
+	P99    time.Duration `json:"p99_ns"`
+	Max    time.Duration `json:"max_ns"`
+}
+
+// OpSummary is the latency of one operation type of a mixed workload
+type OpSummary struct {
+	Op      string         `json:"op"`
+	Count   int            `json:"count"`
+	Latency LatencySummary `json:"latency"`
+}
+
+type Result struct {
+	Label        string            `json:"label,omitempty"`
+	Phase        int               `json:"phase,omitempty"`
+	Name         string            `json:"name"`
+	Scale        int               `json:"scale"`
+	Concurrency  int               `json:"concurrency"`
+	Iterations   uint64            `json:"iterations"`
+	Conflicts    uint64            `json:"conflicts"`
+	Errors       uint64            `json:"errors"`
+	Reads        uint64            `json:"reads"`
+	Writes       uint64            `json:"writes"`
+	Duration     time.Duration     `json:"duration_ns"`
+	Throughput   float64           `json:"throughput"`
+	RecordRate   float64           `json:"ops_per_sec"`
+	TargetRate   float64           `json:"target_rate,omitempty"`
+	SampleRate   float64           `json:"latency_sample_rate,omitempty"`
+	ReadWorkers  int               `json:"read_workers,omitempty"`
+	WriteWorkers int               `json:"write_workers,omitempty"`
+	RowsPerOp    int               `json:"rows_per_op,omitempty"`
+	Latency      LatencySummary    `json:"latency"`
+	Ops          []OpSummary       `json:"ops,omitempty"`
+	Windows      []WindowSummary   `json:"windows,omitempty"`
+	Histogram    []HistogramBucket `json:"histogram,omitempty"`
+	Interrupted  bool              `json:"interrupted,omitempty"`
+	Buckets      []BucketStats     `json:"buckets,omitempty"`
+	Storage      *StorageGrowth    `json:"storage,omitempty"`
+	Memory       *MemoryStats      `json:"memory,omitempty"`
+	Compaction   *Compaction       `json:"compaction,omitempty"`
+	Time         *TimeBreakdown    `json:"time_breakdown,omitempty"`
+	Engine       *EngineStats      `json:"engine_stats,omitempty"`
+	Sync         *SyncStats        `json:"sync,omitempty"`
+	LongReaders  *LongReaders      `json:"long_readers,omitempty"`
+	Burst        *BurstSummary     `json:"burst,omitempty"`
+	SyncMode     string            `json:"sync_mode,omitempty"`
+
+	HistoryCheck *HistoryCheck `json:"history_check,omitempty"`
+	Balances     *Balances     `json:"balances,omitempty"`
+
+	FreePagesBefore int `json:"free_pages_before"`
+	FreePagesAfter  int `json:"free_pages_after"`
+}
+
+type StorageGrowth struct {
+	FileSizeBefore int64  `json:"file_size_before"`
+	FileSizeAfter  int64  `json:"file_size_after"`
+	HistorySeq     uint64 `json:"history_seq"`
+	HistoryAdded   uint64 `json:"history_added"`
+}
+
+type MemoryStats struct {
+	TotalAlloc uint64        `json:"total_alloc_bytes"`
+	Mallocs    uint64        `json:"mallocs"`
+	NumGC      uint32        `json:"num_gc"`
+	GCPause    time.Duration `json:"gc_pause_ns"`
+	HeapInuse  uint64        `json:"heap_inuse_bytes"`
+}
+
+// TimeBreakdown is the mean time per operation spent in the transaction call,
+// in the transaction function and in bucket calls, see timedBackend
+type TimeBreakdown struct {
+	Txn time.Duration `json:"txn_ns"`
+	Fn  time.Duration `json:"fn_ns"`
+	KV  time.Duration `json:"kv_ns"`
+}
+
+// SyncStats are the syncs of -sync-mode batched
+type SyncStats struct {
+	Mode     string        `json:"mode"`
+	Interval time.Duration `json:"interval_ns"`
+	Syncs    uint64        `json:"syncs"`
+	Time     time.Duration `json:"time_ns"`
+}
+
+// LongReaders are the read transactions held open by -long-readers
+type LongReaders struct {
+	Readers int           `json:"readers"`
+	Hold    time.Duration `json:"hold_ns"`
+	Txns    uint64        `json:"txns"`
+}
+
+type Compaction struct {
+	Runs    uint64 `json:"runs"`
+	Trimmed uint64 `json:"trimmed"`
+}
+
+type HistoryCheck struct {
+	RowsAdded int    `json:"rows_added"`
+	SeqAdded  uint64 `json:"seq_added"`
+}
+
+func (h HistoryCheck) OK() bool {
+	return h.RowsAdded >= 0 && uint64(h.RowsAdded) == h.SeqAdded
+}
+
+func (s StorageGrowth) BytesPerTxn() float64 {
+	if s.HistoryAdded == 0 {
+		return 0
+	}
+	return float64(s.FileSizeAfter-s.FileSizeBefore) / float64(s.HistoryAdded)
+}
+
+// IOStats estimates the device load of the measured window. Under
+// -sync-mode always bolt fdatasyncs every commit twice, after the data pages
+// and after the meta page; syncs when growing the file are not counted.
+// Commits are serialized and their write time includes the syncs, so it
+// bounds the commits per second the device allows.
+type IOStats struct {
+	CommitRate float64
+	FsyncRate  float64
+	IOPS       float64
+	CommitTime time.Duration
+	MaxCommits float64
+}
+
+func (r Result) IO() (IOStats, bool) {
+	e := r.Engine
+	if e == nil || e.Commits == 0 || r.Duration <= 0 {
+		return IOStats{}, false
+	}
+	seconds := r.Duration.Seconds()
+	var fsyncs uint64
+	switch {
+	case r.Sync != nil:
+		fsyncs = r.Sync.Syncs
+	case r.SyncMode == "always":
+		fsyncs = 2 * uint64(e.Commits)
+	}
+	io := IOStats{
+		CommitRate: float64(e.Commits) / seconds,
+		FsyncRate:  float64(fsyncs) / seconds,
+		IOPS:       float64(uint64(e.Write)+fsyncs) / seconds,
+		CommitTime: e.WriteTime / time.Duration(e.Commits),
+	}
+	if io.CommitTime > 0 {
+		io.MaxCommits = float64(time.Second) / float64(io.CommitTime)
+	}
+	return io, true
+}
+
+// DeviceBound reports whether the commits came close to what the write time
+// of a commit allows
+func (io IOStats) DeviceBound() bool {
+	return io.MaxCommits > 0 && io.CommitRate >= 0.9*io.MaxCommits
+}
+
+// RetryRate is the number of write retries per operation
+func (r Result) RetryRate() float64 {
+	if r.Iterations == 0 {
+		return 0
+	}
+	return float64(r.Conflicts) / float64(r.Iterations)
+}
+
+// ErrorRate is the fraction of operations that failed
+func (r Result) ErrorRate() float64 {
+	if r.Iterations == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Iterations)
+}
+
+// Completed reports whether any operation finished without an error, without
+// one there are no latencies to report
+func (r Result) Completed() bool {
+	return r.Iterations > r.Errors
+}
+
+func (r Result) Title() string {
+	var tags []string
+	if r.Label != "" {
+		tags = append(tags, r.Label)
+	}
+	if r.Phase > 0 {
+		tags = append(tags, fmt.Sprintf("phase %d", r.Phase))
+	}
+	if tags == nil {
+		return r.Name
+	}
+	return fmt.Sprintf("%s (%s)", r.Name, strings.Join(tags, ", "))
+}
+
+// KeptUp reports whether the achieved throughput stayed within 5% of -rate
+func (r Result) KeptUp() bool {
+	return r.TargetRate == 0 || r.Throughput >= r.TargetRate*0.95
+}
+
+func summarize(l Latencies) LatencySummary {
+	return LatencySummary{
+		Min:    l.Min(),
+		Mean:   l.Mean(),
+		StdDev: l.StdDev(),
+		P50:    l.Percentile(50),
+		P95:    l.Percentile(95),
+		P99:    l.Percentile(99),
+		Max:    l.Max(),
+	}
+}
+
+func summarizeOp(op string, l Latencies) OpSummary {
+	return OpSummary{Op: op, Count: len(l), Latency: summarize(l)}
+}
@@ -0,0 +1,378 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/samber/lo"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Probe samples something at the start and end of the measured window and
+// stores the difference in the result
+type Probe interface {
+	Start() error
+	Stop(r *Result) error
+}
+
+// maxLatencySamples bounds the memory preallocated for latency samples across
+// all workers (1GiB)
+const maxLatencySamples = 1 << 27
+
+// RunOptions sizes one measured window: its workers, pacing and length, and
+// the warmup and progress reports around it. Nops stops it after that many
+// operations instead of after Benchtime.
+type RunOptions struct {
+	Concurrency int
+	Rate        float64
+	Benchtime   time.Duration
+	Nops        uint64
+	Warmup      time.Duration
+	Calibration time.Duration
+	ReportEvery time.Duration
+}
+
+// latencyBufferSize estimates the per worker number of samples of the measured
+// window from the rate observed during warmup, or from nops, with 25% headroom
+func latencyBufferSize(opts RunOptions, warmupOps uint64, warmupTook time.Duration) int64 {
+	expected := float64(warmupOps) / warmupTook.Seconds() * opts.Benchtime.Seconds() * 1.25
+	if opts.Nops > 0 {
+		expected = float64(opts.Nops) * 1.25
+	}
+	perWorker := int64(expected) / int64(opts.Concurrency)
+	return max(1<<10, min(perWorker, maxLatencySamples/int64(opts.Concurrency)))
+}
+
+// Measure runs wl for one measured window with the probes of the options,
+// then the extra ones. Cancelling ctx ends the window early, the result is
+// then marked Interrupted and holds what was measured until then.
+func (bn *Bench) Measure(ctx context.Context, wl Workload, opts RunOptions, extra ...Probe) (Result, error) {
+	if opts.Concurrency <= 0 {
+		return Result{}, fmt.Errorf("concurrency must be > 0, got %d", opts.Concurrency)
+	}
+	var probes []Probe
+	if bn.opts.HistoryKey == "counter" {
+		probes = append(probes, &counterSync{bn: bn})
+	}
+	if bn.opts.LongReaders > 0 {
+		// before engineStatsProbe, so that their transactions are closed when it stops
+		probes = append(probes, &longReaderSet{bn: bn})
+	}
+	if bn.opts.DBPath != "" {
+		probes = append(probes, &fileGrowth{db: bn.db, path: bn.opts.DBPath})
+	}
+	probes = append(probes, &memProbe{})
+	if sb, ok := bn.db.(StatsBackend); ok {
+		probes = append(probes, &freelistProbe{sb: sb}, &engineStatsProbe{sb: sb})
+	}
+	if bn.opts.CheckHistory {
+		probes = append(probes, &historyCheck{db: bn.db})
+	}
+	if bn.opts.CompactHistory {
+		probes = append(probes, &historyCompactor{bn: bn})
+	}
+	if bn.opts.EffectiveSyncMode() == "batched" {
+		probes = append(probes, &periodicSync{bn: bn})
+	}
+	probes = append(probes, extra...)
+	var before Balances
+	if bn.opts.Verify {
+		var err error
+		if before, err = bn.verifyBalances(); err != nil {
+			return Result{}, err
+		}
+		bn.appliedDelta.Store(0)
+	}
+	result, err := bn.run(ctx, wl, opts, probes)
+	if err != nil {
+		return result, err
+	}
+	if bn.opts.Verify {
+		balances, err := bn.verifyBalances()
+		if err != nil {
+			return result, err
+		}
+		balances.AccountsChange = balances.Accounts - before.Accounts
+		balances.Applied = bn.appliedDelta.Load()
+		result.Balances = &balances
+		if !balances.OK() {
+			slog.Error("balances do not match", "accounts", balances.Accounts, "tellers", balances.Tellers,
+				"branches", balances.Branches, "accounts_change", balances.AccountsChange, "applied", balances.Applied, "imbalance", balances.Imbalance())
+		}
+	}
+	if sb, ok := bn.db.(StatsBackend); ok && bn.opts.BucketStats {
+		for _, table := range bn.opts.Tables(wl) {
+			for _, name := range bucketNames(table, bn.opts.Shards) {
+				st, err := sb.BucketStats(name)
+				if err != nil {
+					return result, err
+				}
+				result.Buckets = append(result.Buckets, st)
+			}
+		}
+	}
+	return result, nil
+}
+
+// run runs the workers of one measured window until it ends or ctx does
+func (bn *Bench) run(ctx context.Context, wl Workload, opts RunOptions, probes []Probe) (Result, error) {
+	var iterations atomic.Uint64
+	var measuring atomic.Bool
+	finishTimer, cancelFunc := context.WithCancel(ctx)
+	defer cancelFunc()
+	trace := bn.opts.Trace
+	sampleRate, latencyWindow := bn.opts.SampleRate, bn.opts.LatencyWindow
+	var wg sync.WaitGroup
+	wg.Add(opts.Concurrency)
+	// samples are kept per worker and per operation type, index 0 holds
+	// writes and 1 holds reads
+	latencies := make([][2]Latencies, opts.Concurrency)
+	// marks[worker][kind][w] is the number of samples the worker had
+	// recorded of the kind when the w-th -latency-window began
+	marks := make([][2][]int, opts.Concurrency)
+	// started is set before measuring, which orders it before the reads
+	// of the workers
+	var started time.Time
+	// burstLatencies[worker] holds the -burst-idle samples, cold ones at
+	// index 0 and warm ones at 1
+	burstLatencies := make([][2]Latencies, opts.Concurrency)
+	histograms := make([]Histogram, opts.Concurrency)
+	bn.setLive(&iterations, histograms)
+	var warmupOps atomic.Uint64
+	// claimed counts the measured operations handed out under -nops
+	var claimed atomic.Uint64
+	var bufferCap atomic.Int64
+	bufferCap.Store(1 << 16)
+	// the pacer is restarted when measurement starts so that the backlog or
+	// slack of the warmup does not carry over
+	var pace atomic.Pointer[pacer]
+	pace.Store(newPacer(opts.Rate))
+	for worker := range opts.Concurrency {
+		go func() {
+			defer wg.Done()
+			var local [2]Latencies
+			var mark [2][]int
+			var traced []traceEvent
+			var burstLocal [2]Latencies
+			defer func() {
+				latencies[worker], marks[worker], burstLatencies[worker] = local, mark, burstLocal
+				if trace != nil {
+					trace.flush(&traced)
+				}
+			}()
+			rng := bn.NewRng(uint64(worker))
+			rng.worker, rng.workers = worker, opts.Concurrency
+			for {
+				select {
+				case <-finishTimer.Done():
+					return
+				default:
+					due, ok := pace.Load().wait(finishTimer)
+					if !ok {
+						return
+					}
+					op, isRead := wl.pick(worker, bn.opts.WritePool, rng)
+					if !measuring.Load() {
+						op(bn, finishTimer, rng)
+						warmupOps.Add(1)
+						continue
+					}
+					var burstAt time.Duration
+					if bn.opts.bursts() {
+						var ok bool
+						if burstAt, ok = bn.opts.waitBurst(finishTimer, started); !ok {
+							return
+						}
+					}
+					if opts.Nops > 0 && claimed.Add(1) > opts.Nops {
+						// the operations in flight still complete and count
+						return
+					}
+					if local[0] == nil && local[1] == nil {
+						n := int64(float64(bufferCap.Load())*min(sampleRate, 1)) + 1
+						reads := n * int64(wl.readShare(worker, bn.opts.WritePool)) / 100
+						local[0] = make(Latencies, 0, n-reads)
+						local[1] = make(Latencies, 0, reads)
+					}
+					sampled := sampleRate >= 1 || rng.Float64() < sampleRate
+					// -trace records the latency of every operation
+					timed := sampled || trace != nil
+					var start time.Time
+					if timed {
+						// under -rate an operation issued late to work off a
+						// backlog counts from when it was due, but not from
+						// before the measurement, the warmup backlog does not
+						// carry over
+						start = time.Now()
+						if !due.IsZero() && due.Before(start) {
+							start = lo.Ternary(due.Before(started), started, due)
+						}
+					}
+					rng.firstID = -1
+					err := op(bn, finishTimer, rng)
+					var took time.Duration
+					if timed {
+						took = time.Since(start)
+					}
+					if err != nil && finishTimer.Err() != nil && errors.Is(err, finishTimer.Err()) {
+						// cut short by the end of the run
+						return
+					}
+					iterations.Add(1)
+					lo.Ternary(isRead, &bn.readOps, &bn.writeOps).Add(1)
+					if trace != nil {
+						trace.add(&traced, traceEvent{start: start, worker: worker, op: lo.Ternary(isRead, wl.ReadOp, wl.WriteOp),
+							latency: took, key: rng.firstID, failed: err != nil})
+					}
+					if err != nil {
+						bn.opErrors.Add(1)
+						bn.errLog.Log(err)
+						continue
+					}
+					if !sampled {
+						continue
+					}
+					kind := lo.Ternary(isRead, 1, 0)
+					if latencyWindow > 0 {
+						w := int(start.Sub(started) / latencyWindow)
+						for len(mark[kind]) <= w {
+							mark[kind] = append(mark[kind], len(local[kind]))
+						}
+					}
+					local[kind] = append(local[kind], took)
+					if bn.opts.bursts() {
+						part := lo.Ternary(burstAt < bn.opts.BurstCold, 0, 1)
+						burstLocal[part] = append(burstLocal[part], took)
+					}
+					histograms[worker].Record(took)
+				}
+			}
+		}()
+	}
+
+	// the warmup doubles as the calibration burst that sizes the latency
+	// buffers, so that recording does not allocate in the measured window
+	if warmupFor := max(opts.Warmup, opts.Calibration); warmupFor > 0 {
+		slog.Info("warming up...", "warmup", warmupFor)
+		warmupStart := time.Now()
+		select {
+		case <-time.After(warmupFor):
+		case <-finishTimer.Done():
+		}
+		bufferCap.Store(latencyBufferSize(opts, warmupOps.Load(), time.Since(warmupStart)))
+	}
+	slog.Info("testing...")
+	bn.conflicts.Store(0)
+	bn.opErrors.Store(0)
+	bn.readOps.Store(0)
+	bn.writeOps.Store(0)
+	bn.timing.reset()
+	for _, p := range probes {
+		if err := p.Start(); err != nil {
+			cancelFunc()
+			wg.Wait()
+			return Result{}, err
+		}
+	}
+	pace.Store(newPacer(opts.Rate))
+	started = time.Now()
+	measuring.Store(true)
+	if opts.Nops == 0 {
+		time.AfterFunc(opts.Benchtime, cancelFunc)
+	}
+	if opts.ReportEvery > 0 {
+		go bn.reportProgress(finishTimer, opts.ReportEvery, &iterations, started)
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		slog.Warn("run interrupted, reporting partial results", "elapsed", elapsed)
+	}
+	var all, reads, writes []Latencies
+	for _, l := range latencies {
+		all = append(all, l[0], l[1])
+		writes = append(writes, l[0])
+		reads = append(reads, l[1])
+	}
+	merged := mergeLatencies(all)
+	var ops []OpSummary
+	if wl.Read != nil {
+		ops = append(ops, summarizeOp(wl.ReadOp, mergeLatencies(reads)))
+	}
+	if wl.Write != nil {
+		ops = append(ops, summarizeOp(wl.WriteOp, mergeLatencies(writes)))
+	}
+	var histogram Histogram
+	for i := range histograms {
+		histogram.Add(&histograms[i])
+	}
+
+	iterationsDone, conflicts, opErrors := iterations.Load(), bn.conflicts.Load(), bn.opErrors.Load()
+	readOps, writeOps := bn.readOps.Load(), bn.writeOps.Load()
+	slog.Info("throughtput results", "concurrency", opts.Concurrency, "iterations", iterationsDone, "conflicts", conflicts, "errors", opErrors)
+	result := Result{
+		Name:         wl.Name,
+		Scale:        bn.opts.Scale,
+		Concurrency:  opts.Concurrency,
+		Iterations:   iterationsDone,
+		Conflicts:    conflicts,
+		Errors:       opErrors,
+		Reads:        readOps,
+		Writes:       writeOps,
+		Duration:     elapsed,
+		Throughput:   float64(iterationsDone) / elapsed.Seconds(),
+		RecordRate:   (float64(readOps)*wl.records(true) + float64(writeOps)*wl.records(false)) / elapsed.Seconds(),
+		TargetRate:   opts.Rate,
+		SampleRate:   lo.Ternary(sampleRate < 1, sampleRate, 0),
+		ReadWorkers:  lo.Ternary(bn.opts.pools(), opts.Concurrency-bn.opts.WritePool, 0),
+		WriteWorkers: lo.Ternary(bn.opts.pools(), bn.opts.WritePool, 0),
+		RowsPerOp:    wl.RowsPerOp,
+		Time:         bn.timing.breakdown(iterationsDone),
+		Latency:      summarize(merged),
+		Ops:          ops,
+		Windows:      windowSummaries(latencies, marks, latencyWindow, elapsed),
+		Burst:        bn.opts.burstSummary(burstLatencies, iterationsDone, elapsed),
+		Histogram:    histogram.Buckets(),
+		Interrupted:  interrupted,
+		SyncMode:     bn.opts.EffectiveSyncMode(),
+	}
+	if !result.Completed() {
+		slog.Warn("no operations completed", "iterations", iterationsDone, "errors", opErrors)
+	}
+	if !result.KeptUp() {
+		slog.Warn("could not keep up with the target rate", "target", opts.Rate, "achieved", result.Throughput)
+	}
+	var err error
+	for _, p := range probes {
+		err = errors.Join(err, p.Stop(&result))
+	}
+	return result, err
+}
+
+func (bn *Bench) reportProgress(ctx context.Context, every time.Duration, iterations *atomic.Uint64, started time.Time) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	last, lastTime := uint64(0), started
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			current := iterations.Load()
+			attrs := []any{
+				"elapsed", now.Sub(started).Round(time.Second),
+				"throughput", fmt.Sprintf("%0.1f", float64(current-last)/now.Sub(lastTime).Seconds()),
+				"errors", bn.opErrors.Load(),
+			}
+			if sb, ok := bn.db.(StatsBackend); ok {
+				attrs = append(attrs, "free_pages", sb.FreePages())
+			}
+			slog.Info("progress", attrs...)
+			last, lastTime = current, now
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/samber/lo"
+	"os"
+)
+
+// SchemaBucket is a custom bucket of the schema workload, holding Keys raw
+// values of ValueSize bytes keyed like the built in tables
+type SchemaBucket struct {
+	Name      string `json:"name"`
+	Keys      int    `json:"keys"`
+	ValueSize int    `json:"value_size"`
+}
+
+type schemaFile struct {
+	Buckets []SchemaBucket `json:"buckets"`
+}
+
+// LoadSchema reads and checks the schema file at path, a JSON object like
+// {"buckets": [{"name": "users", "keys": 100000, "value_size": 200}]}
+func LoadSchema(path string) ([]SchemaBucket, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f schemaFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(f.Buckets) == 0 {
+		return nil, fmt.Errorf("%s: no buckets", path)
+	}
+	seen := make(map[string]bool)
+	for _, b := range f.Buckets {
+		switch {
+		case b.Name == "":
+			return nil, fmt.Errorf("%s: bucket without a name", path)
+		case seen[b.Name]:
+			return nil, fmt.Errorf("%s: bucket %q given twice", path, b.Name)
+		case hasTable(allTables, []byte(b.Name)):
+			return nil, fmt.Errorf("%s: bucket %q is a built in table", path, b.Name)
+		case b.Keys <= 0:
+			return nil, fmt.Errorf("%s: bucket %q needs keys > 0", path, b.Name)
+		case b.ValueSize < 0:
+			return nil, fmt.Errorf("%s: bucket %q needs value_size >= 0", path, b.Name)
+		}
+		seen[b.Name] = true
+	}
+	return f.Buckets, nil
+}
+
+func schemaTables(schema []SchemaBucket) [][]byte {
+	return lo.Map(schema, func(b SchemaBucket, _ int) []byte { return []byte(b.Name) })
+}
+
+// schemaWorkload reads or overwrites one key of a random bucket per
+// operation, half of them reads unless -readpct says otherwise. Buckets are
+// picked uniformly and keys by -distribution within the bucket.
+func schemaWorkload(o Options) Workload {
+	schema := o.Schema
+	pick := func(rng *Rng) (SchemaBucket, int) {
+		b := schema[rng.IntN(len(schema))]
+		return b, rng.ID(b.Keys)
+	}
+	read := func(bn *Bench, ctx context.Context, rng *Rng) error {
+		b, id := pick(rng)
+		return bn.db.View(func(txn Txn) error {
+			if txn.Bucket([]byte(b.Name)).Get(keyFor(id)) == nil {
+				return fmt.Errorf("%s%d: %w", b.Name, id, errNotFound)
+			}
+			return nil
+		})
+	}
+	write := func(bn *Bench, ctx context.Context, rng *Rng) error {
+		b, id := pick(rng)
+		value := []byte(bn.newFiller(rng, b.ValueSize))
+		return bn.db.Update(func(txn Txn) error {
+			return txn.Bucket([]byte(b.Name)).Put(keyFor(id), value)
+		})
+	}
+	return Workload{
+		Name:    "schema",
+		Read:    read,
+		Write:   write,
+		ReadOp:  "get",
+		WriteOp: "put",
+		ReadPct: lo.Ternary(o.ReadPct >= 0, o.ReadPct, 50),
+		Tables:  schemaTables(schema),
+		Schema:  schema,
+	}
+}
+
+// fillSchema fills the buckets of the schema among tables, returning the number
+// of rows it wrote
+func (bn *Bench) fillSchema(tables [][]byte) (int, error) {
+	rows := 0
+	for _, b := range bn.opts.Schema {
+		if hasTable(tables, []byte(b.Name)) {
+			n, err := bn.fillTable([]byte(b.Name), b.Keys, b.ValueSize, func(it int, filler string) []byte {
+				return []byte(filler)
+			})
+			rows += n
+			if err != nil {
+				return rows, err
+			}
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,55 @@
+package bench
+
+// nextHistoryKey allocates the key of a new history record in b
+func (bn *Bench) nextHistoryKey(b Bucket) (uint64, error) {
+	if bn.opts.HistoryKey == "counter" {
+		return bn.historyCounter.Add(1), nil
+	}
+	return b.NextSequence()
+}
+
+// lastHistoryKey is the newest history key allocated in b. Under -history-key
+// counter the bucket sequence is only written around the measured window, so
+// during it the live counter is read instead.
+func (bn *Bench) lastHistoryKey(b Bucket) uint64 {
+	if bn.opts.HistoryKey == "counter" {
+		return bn.historyCounter.Load()
+	}
+	return b.Sequence()
+}
+
+// loadHistoryCounter starts the counter from the sequence of the history
+// bucket, if there is one
+func (bn *Bench) loadHistoryCounter() error {
+	return bn.db.View(func(txn Txn) error {
+		if b := txn.Bucket(historyPrefix); b != nil {
+			bn.historyCounter.Store(b.Sequence())
+		}
+		return nil
+	})
+}
+
+// counterSync is a probe storing the counter as the history sequence at the
+// start and the end of the measured window. It has to come before the
+// probes reading the sequence.
+type counterSync struct {
+	bn *Bench
+}
+
+func (c *counterSync) store() error {
+	return c.bn.db.Update(func(txn Txn) error {
+		b := txn.Bucket(historyPrefix)
+		if b == nil {
+			return nil
+		}
+		return b.SetSequence(c.bn.historyCounter.Load())
+	})
+}
+
+func (c *counterSync) Start() error {
+	return c.store()
+}
+
+func (c *counterSync) Stop(r *Result) error {
+	return c.store()
+}
@@ -0,0 +1,235 @@
+package bench
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// shardedBackend stores each table in -shards buckets. Keys are routed by a
+// hash, so every bucket holds a random subset of the ids; cursors merge the
+// buckets back into one key order.
+type shardedBackend struct {
+	Backend
+	shards int
+}
+
+// shardedStatsBackend passes stats through for backends that have them, the
+// names it is asked for are the physical ones returned by bucketNames
+type shardedStatsBackend struct {
+	shardedBackend
+	StatsBackend
+}
+
+func newShardedBackend(db Backend, shards int) Backend {
+	s := shardedBackend{Backend: db, shards: shards}
+	if sb, ok := db.(StatsBackend); ok {
+		return shardedStatsBackend{shardedBackend: s, StatsBackend: sb}
+	}
+	return s
+}
+
+// bucketNames returns the buckets a table is stored in
+func bucketNames(table []byte, shards int) [][]byte {
+	if shards <= 1 {
+		return [][]byte{table}
+	}
+	names := make([][]byte, shards)
+	for i := range names {
+		names[i] = strconv.AppendInt(append([]byte(nil), table...), int64(i), 10)
+	}
+	return names
+}
+
+func (s shardedBackend) Update(fn func(txn Txn) error) error {
+	return s.Backend.Update(func(txn Txn) error { return fn(shardedTxn{txn, s.shards}) })
+}
+
+func (s shardedBackend) View(fn func(txn Txn) error) error {
+	return s.Backend.View(func(txn Txn) error { return fn(shardedTxn{txn, s.shards}) })
+}
+
+func (s shardedBackend) Batch(fn func(txn Txn) error) error {
+	return s.Backend.Batch(func(txn Txn) error { return fn(shardedTxn{txn, s.shards}) })
+}
+
+type shardedTxn struct {
+	Txn
+	shards int
+}
+
+func (t shardedTxn) Bucket(name []byte) Bucket {
+	var b shardedBucket
+	for _, shard := range bucketNames(name, t.shards) {
+		sb := t.Txn.Bucket(shard)
+		if sb == nil {
+			return nil
+		}
+		b = append(b, sb)
+	}
+	return b
+}
+
+func (t shardedTxn) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	var b shardedBucket
+	for _, shard := range bucketNames(name, t.shards) {
+		sb, err := t.Txn.CreateBucketIfNotExists(shard)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, sb)
+	}
+	return b, nil
+}
+
+// shardedBucket keeps the sequence in its first shard. ForEach visits the
+// shards one after another, so unlike Cursor it is not in key order.
+type shardedBucket []Bucket
+
+// shard picks the bucket of key by its FNV-1a hash
+func (b shardedBucket) shard(key []byte) Bucket {
+	h := uint32(2166136261)
+	for _, c := range key {
+		h = (h ^ uint32(c)) * 16777619
+	}
+	return b[h%uint32(len(b))]
+}
+
+func (b shardedBucket) Get(key []byte) []byte {
+	return b.shard(key).Get(key)
+}
+
+func (b shardedBucket) Put(key []byte, value []byte) error {
+	return b.shard(key).Put(key, value)
+}
+
+func (b shardedBucket) Delete(key []byte) error {
+	return b.shard(key).Delete(key)
+}
+
+func (b shardedBucket) NextSequence() (uint64, error) {
+	return b[0].NextSequence()
+}
+
+func (b shardedBucket) Sequence() uint64 {
+	return b[0].Sequence()
+}
+
+func (b shardedBucket) SetSequence(v uint64) error {
+	return b[0].SetSequence(v)
+}
+
+func (b shardedBucket) ForEach(fn func(k, v []byte) error) error {
+	for _, shard := range b {
+		if err := shard.ForEach(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bucket routes nested buckets by the hash of their name like keys
+func (b shardedBucket) Bucket(name []byte) Bucket {
+	return b.shard(name).Bucket(name)
+}
+
+func (b shardedBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	return b.shard(name).CreateBucketIfNotExists(name)
+}
+
+func (b shardedBucket) Cursor() Cursor {
+	c := &mergeCursor{cursors: make([]Cursor, len(b)), heads: make([][2][]byte, len(b)), cur: -1}
+	for i, shard := range b {
+		c.cursors[i] = shard.Cursor()
+	}
+	return c
+}
+
+// mergeCursor merges the cursors of all shards. Each keeps the key it is on
+// in heads, the merged position is the smallest head when moving forward and
+// the largest when moving backward. Changing direction repositions all of
+// them around the current key, which lives in exactly one shard.
+type mergeCursor struct {
+	cursors []Cursor
+	heads   [][2][]byte
+	forward bool
+	cur     int
+}
+
+func (c *mergeCursor) First() ([]byte, []byte) {
+	for i, sc := range c.cursors {
+		c.heads[i][0], c.heads[i][1] = sc.First()
+	}
+	return c.pick(true)
+}
+
+func (c *mergeCursor) Last() ([]byte, []byte) {
+	for i, sc := range c.cursors {
+		c.heads[i][0], c.heads[i][1] = sc.Last()
+	}
+	return c.pick(false)
+}
+
+func (c *mergeCursor) Seek(seek []byte) ([]byte, []byte) {
+	for i, sc := range c.cursors {
+		c.heads[i][0], c.heads[i][1] = sc.Seek(seek)
+	}
+	return c.pick(true)
+}
+
+func (c *mergeCursor) Next() ([]byte, []byte) {
+	if c.cur < 0 {
+		return nil, nil
+	}
+	if !c.forward {
+		key := c.heads[c.cur][0]
+		for i, sc := range c.cursors {
+			k, v := sc.Seek(key)
+			if bytes.Equal(k, key) {
+				k, v = sc.Next()
+			}
+			c.heads[i][0], c.heads[i][1] = k, v
+		}
+		return c.pick(true)
+	}
+	c.heads[c.cur][0], c.heads[c.cur][1] = c.cursors[c.cur].Next()
+	return c.pick(true)
+}
+
+func (c *mergeCursor) Prev() ([]byte, []byte) {
+	if c.cur < 0 {
+		return nil, nil
+	}
+	if c.forward {
+		key := c.heads[c.cur][0]
+		for i, sc := range c.cursors {
+			// a shard without keys >= key only has smaller ones
+			k, v := sc.Seek(key)
+			if k == nil {
+				k, v = sc.Last()
+			} else {
+				k, v = sc.Prev()
+			}
+			c.heads[i][0], c.heads[i][1] = k, v
+		}
+		return c.pick(false)
+	}
+	c.heads[c.cur][0], c.heads[c.cur][1] = c.cursors[c.cur].Prev()
+	return c.pick(false)
+}
+
+// pick makes the smallest (forward) or largest head the current position
+func (c *mergeCursor) pick(forward bool) ([]byte, []byte) {
+	c.forward, c.cur = forward, -1
+	for i, h := range c.heads {
+		if h[0] == nil {
+			continue
+		}
+		if c.cur < 0 || (bytes.Compare(h[0], c.heads[c.cur][0]) < 0) == forward {
+			c.cur = i
+		}
+	}
+	if c.cur < 0 {
+		return nil, nil
+	}
+	return c.heads[c.cur][0], c.heads[c.cur][1]
+}
@@ -0,0 +1,161 @@
+package bench
+
+import (
+	"log/slog"
+	"os"
+	"runtime"
+	"time"
+)
+
+// fileGrowth records how much the database file and the history bucket grew
+// during the measured window
+type fileGrowth struct {
+	db         Backend
+	path       string
+	sizeBefore int64
+	seqBefore  uint64
+}
+
+func (f *fileGrowth) historySequence() (uint64, error) {
+	var seq uint64
+	err := f.db.View(func(txn Txn) error {
+		if b := txn.Bucket(historyPrefix); b != nil {
+			seq = b.Sequence()
+		}
+		return nil
+	})
+	return seq, err
+}
+
+func (f *fileGrowth) Start() error {
+	st, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	f.sizeBefore = st.Size()
+	f.seqBefore, err = f.historySequence()
+	return err
+}
+
+func (f *fileGrowth) Stop(r *Result) error {
+	st, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	seq, err := f.historySequence()
+	if err != nil {
+		return err
+	}
+	r.Storage = &StorageGrowth{
+		FileSizeBefore: f.sizeBefore,
+		FileSizeAfter:  st.Size(),
+		HistorySeq:     seq,
+		HistoryAdded:   seq - f.seqBefore,
+	}
+	return nil
+}
+
+type freelistProbe struct {
+	sb     StatsBackend
+	before int
+}
+
+func (f *freelistProbe) Start() error {
+	f.before = f.sb.FreePages()
+	return nil
+}
+
+func (f *freelistProbe) Stop(r *Result) error {
+	r.FreePagesBefore = f.before
+	r.FreePagesAfter = f.sb.FreePages()
+	return nil
+}
+
+// historyCheck verifies that every history sequence number handed out during
+// the measured window produced its own row. A key generation bug would
+// otherwise silently overwrite history rows and invalidate the transaction
+// count. Rows and sequence are read in the same transaction, so the check is
+// not affected by writes in flight.
+type historyCheck struct {
+	db         Backend
+	rowsBefore int
+	seqBefore  uint64
+}
+
+func (h *historyCheck) snapshot() (rows int, seq uint64, err error) {
+	err = h.db.View(func(txn Txn) error {
+		b := txn.Bucket(historyPrefix)
+		seq = b.Sequence()
+		return b.ForEach(func(k, v []byte) error {
+			rows++
+			return nil
+		})
+	})
+	return rows, seq, err
+}
+
+func (h *historyCheck) Start() (err error) {
+	h.rowsBefore, h.seqBefore, err = h.snapshot()
+	return err
+}
+
+func (h *historyCheck) Stop(r *Result) error {
+	rows, seq, err := h.snapshot()
+	if err != nil {
+		return err
+	}
+	r.HistoryCheck = &HistoryCheck{
+		RowsAdded: rows - h.rowsBefore,
+		SeqAdded:  seq - h.seqBefore,
+	}
+	if !r.HistoryCheck.OK() {
+		slog.Error("history rows do not match sequence", "rows_added", r.HistoryCheck.RowsAdded, "seq_added", r.HistoryCheck.SeqAdded)
+	}
+	return nil
+}
+
+// engineStatsProbe records the engine counters of the measured window. Read
+// transactions left open at its end keep the pages freed since they started
+// pending, so the freelist cannot be reused and the file grows.
+type engineStatsProbe struct {
+	sb     StatsBackend
+	before EngineStats
+}
+
+func (e *engineStatsProbe) Start() error {
+	e.before = e.sb.EngineStats()
+	return nil
+}
+
+func (e *engineStatsProbe) Stop(r *Result) error {
+	st := e.sb.EngineStats().Sub(e.before)
+	if st.OpenTxns > 0 {
+		slog.Warn("read transactions still open after the run, freed pages stay pending", "open", st.OpenTxns, "pending_pages", st.PendingPages)
+	}
+	r.Engine = &st
+	return nil
+}
+
+// memProbe records allocations and GC activity of the measured window, to
+// tell GC pauses apart from fsync stalls in the latency tail
+type memProbe struct {
+	before runtime.MemStats
+}
+
+func (m *memProbe) Start() error {
+	runtime.ReadMemStats(&m.before)
+	return nil
+}
+
+func (m *memProbe) Stop(r *Result) error {
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	r.Memory = &MemoryStats{
+		TotalAlloc: after.TotalAlloc - m.before.TotalAlloc,
+		Mallocs:    after.Mallocs - m.before.Mallocs,
+		NumGC:      after.NumGC - m.before.NumGC,
+		GCPause:    time.Duration(after.PauseTotalNs - m.before.PauseTotalNs),
+		HeapInuse:  after.HeapInuse,
+	}
+	return nil
+}
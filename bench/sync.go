@@ -0,0 +1,57 @@
+package bench
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// periodicSync is a probe syncing the database every -sync-interval during
+// the measured window, and once more at its end so that nothing committed in
+// the window stays unsynced
+type periodicSync struct {
+	bn    *Bench
+	stop  chan struct{}
+	wg    sync.WaitGroup
+	syncs uint64
+	took  time.Duration
+	err   error
+}
+
+func (p *periodicSync) sync() {
+	started := time.Now()
+	if err := p.bn.db.Sync(); err != nil {
+		slog.Error("sync failed", "err", err)
+		p.err = err
+		return
+	}
+	p.took += time.Since(started)
+	p.syncs++
+}
+
+func (p *periodicSync) Start() error {
+	p.stop = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.bn.opts.SyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				p.sync()
+				return
+			case <-ticker.C:
+				p.sync()
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *periodicSync) Stop(r *Result) error {
+	close(p.stop)
+	p.wg.Wait()
+	r.Sync = &SyncStats{Mode: "batched", Interval: p.bn.opts.SyncInterval, Syncs: p.syncs, Time: p.took}
+	return p.err
+}
@@ -0,0 +1,127 @@
+package bench
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// timing holds the nanoseconds spent in transactions, in the functions run
+// by them, and in bucket calls, summed over all workers
+type timing struct {
+	txn, fn, kv atomic.Int64
+}
+
+func (t *timing) reset() {
+	if t == nil {
+		return
+	}
+	t.txn.Store(0)
+	t.fn.Store(0)
+	t.kv.Store(0)
+}
+
+// breakdown returns the mean time per operation of each part, nil without
+// -time-breakdown
+func (t *timing) breakdown(ops uint64) *TimeBreakdown {
+	if t == nil || ops == 0 {
+		return nil
+	}
+	return &TimeBreakdown{
+		Txn: time.Duration(t.txn.Load() / int64(ops)),
+		Fn:  time.Duration(t.fn.Load() / int64(ops)),
+		KV:  time.Duration(t.kv.Load() / int64(ops)),
+	}
+}
+
+// since adds the time since start to counter
+func since(counter *atomic.Int64, start time.Time) {
+	counter.Add(int64(time.Since(start)))
+}
+
+// timedBackend measures where the time of an operation goes: the whole
+// Update/View/Batch call including commit and fsync, the transaction function
+// within it, and the bucket calls within that. The rest of the function is
+// encoding and workload logic.
+type timedBackend struct {
+	Backend
+	t *timing
+}
+
+type timedStatsBackend struct {
+	timedBackend
+	StatsBackend
+}
+
+func newTimedBackend(db Backend, timing *timing) Backend {
+	t := timedBackend{Backend: db, t: timing}
+	if sb, ok := db.(StatsBackend); ok {
+		return timedStatsBackend{timedBackend: t, StatsBackend: sb}
+	}
+	return t
+}
+
+func (t timedBackend) timedFn(fn func(txn Txn) error) func(txn Txn) error {
+	return func(txn Txn) error {
+		defer since(&t.t.fn, time.Now())
+		return fn(timedTxn{txn, t.t})
+	}
+}
+
+func (t timedBackend) Update(fn func(txn Txn) error) error {
+	defer since(&t.t.txn, time.Now())
+	return t.Backend.Update(t.timedFn(fn))
+}
+
+func (t timedBackend) View(fn func(txn Txn) error) error {
+	defer since(&t.t.txn, time.Now())
+	return t.Backend.View(t.timedFn(fn))
+}
+
+func (t timedBackend) Batch(fn func(txn Txn) error) error {
+	defer since(&t.t.txn, time.Now())
+	return t.Backend.Batch(t.timedFn(fn))
+}
+
+type timedTxn struct {
+	Txn
+	t *timing
+}
+
+func (t timedTxn) Bucket(name []byte) Bucket {
+	b := t.Txn.Bucket(name)
+	if b == nil {
+		return nil
+	}
+	return timedBucket{b, t.t}
+}
+
+// innerBucket lets timedBucket embed a Bucket without the field name hiding
+// its Bucket method
+type innerBucket interface {
+	Bucket
+}
+
+type timedBucket struct {
+	innerBucket
+	t *timing
+}
+
+func (b timedBucket) Get(key []byte) []byte {
+	defer since(&b.t.kv, time.Now())
+	return b.innerBucket.Get(key)
+}
+
+func (b timedBucket) Put(key []byte, value []byte) error {
+	defer since(&b.t.kv, time.Now())
+	return b.innerBucket.Put(key, value)
+}
+
+func (b timedBucket) Delete(key []byte) error {
+	defer since(&b.t.kv, time.Now())
+	return b.innerBucket.Delete(key)
+}
+
+func (b timedBucket) NextSequence() (uint64, error) {
+	defer since(&b.t.kv, time.Now())
+	return b.innerBucket.NextSequence()
+}
@@ -0,0 +1,174 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"github.com/samber/lo"
+	"time"
+)
+
+// accountUpdate is the change of one account by a TPC-B transaction
+type accountUpdate struct {
+	aid    int
+	adelta int64
+}
+
+// ReadWrite runs a TPC-B transaction updating -writes-per-txn accounts. The
+// parameters are drawn up front so that a retry or Batch replay runs the
+// same ones, in the order of the single account transaction for 1.
+func (bn *Bench) ReadWrite(ctx context.Context, rng *Rng) error {
+	accounts := make([]accountUpdate, bn.opts.WritesPerTxn)
+	for i := range accounts {
+		accounts[i].aid = rng.ID(bn.opts.Scale * bn.opts.AccountsPerScale)
+	}
+	tid := rng.ID(bn.opts.Scale * bn.opts.TellersPerScale)
+	bid := rng.ID(bn.opts.Scale * bn.opts.BranchesPerScale)
+	for i := range accounts {
+		accounts[i].adelta = bn.randomDelta(rng)
+	}
+	var failed error
+	update := func(txn Txn) error {
+		failed = bn.readWriteTxn(txn, accounts, tid, bid)
+		return failed
+	}
+
+	err := bn.commitWithRetry(ctx, lo.Ternary(bn.opts.Batch, bn.db.Batch, bn.db.Update), update, func() bool { return failed == nil }, rng)
+	if err == nil {
+		for _, a := range accounts {
+			bn.appliedDelta.Add(a.adelta)
+		}
+	}
+	return err
+}
+
+// retryDelay is the backoff before the retry-th retry, -retry-backoff
+// doubled per retry up to -retry-backoff-max without overflowing
+func (bn *Bench) retryDelay(retry int) time.Duration {
+	d := bn.opts.RetryBackoff
+	for i := 0; i < retry && d > 0 && d < bn.opts.RetryBackoffMax; i++ {
+		d *= 2
+	}
+	return min(d, bn.opts.RetryBackoffMax)
+}
+
+// commitWithRetry retries commit while retryable reports the error as
+// transient, sleeping an exponentially growing, jittered backoff in between.
+// Every retry is counted as a conflict. Retrying stops once ctx is done.
+func (bn *Bench) commitWithRetry(ctx context.Context, commit func(fn func(txn Txn) error) error, fn func(txn Txn) error, retryable func() bool, rng *Rng) error {
+	err := commit(fn)
+	for retry := 0; err != nil && retryable(); retry++ {
+		if retry >= bn.opts.MaxRetries {
+			return fmt.Errorf("giving up after %d retries: %w", retry, err)
+		}
+		bn.conflicts.Add(1)
+		backoff := bn.retryDelay(retry)
+		if backoff > 0 {
+			select {
+			case <-time.After(time.Duration(rng.Int64N(int64(backoff)) + 1)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		err = commit(fn)
+	}
+	return err
+}
+
+// readWriteTxn adds the delta of every account to it and their sum to the
+// teller and the branch, recording the sum in a history row of the first
+// account. It must be safe to run more than once: Batch rolls back the whole
+// batch when one of its functions fails and replays the others. Everything it
+// reads and writes goes through txn, including the history key from
+// NextSequence, so a replay sees the rolled back state and allocates the same
+// sequence range again instead of skipping or reusing keys. Keys from
+// -history-key counter are not rolled back, a replay skips them instead.
+func (bn *Bench) readWriteTxn(txn Txn, accounts []accountUpdate, tid, bid int) error {
+	accBucket := txn.Bucket(accountPrefix)
+	var adelta int64
+	for _, a := range accounts {
+		//SELECT abalance FROM pgbench_accounts WHERE aid = :aid;
+		var acc Account
+		if err := bn.getRecord(accBucket, accountPrefix, a.aid, &acc); err != nil {
+			return err
+		}
+
+		//UPDATE pgbench_accounts SET abalance = abalance + :delta WHERE aid = :aid;
+		acc.Abalance += a.adelta
+		if err := accBucket.Put(keyFor(a.aid), bn.valueFor(acc)); err != nil {
+			return err
+		}
+		adelta += a.adelta
+	}
+
+	//UPDATE pgbench_tellers SET tbalance = tbalance + :delta WHERE tid = :tid;
+	tellerBucket := txn.Bucket(tellerPrefix)
+	var teller Teller
+	if err := bn.getRecord(tellerBucket, tellerPrefix, tid, &teller); err != nil {
+		return err
+	}
+	teller.Tbalance += adelta
+	if err := tellerBucket.Put(keyFor(tid), bn.valueFor(teller)); err != nil {
+		return err
+	}
+
+	//UPDATE pgbench_branches SET bbalance = bbalance + :delta WHERE bid = :bid;
+	branchBucket := txn.Bucket(branchPrefix)
+	var branch Branche
+	if err := bn.getRecord(branchBucket, branchPrefix, bid, &branch); err != nil {
+		return err
+	}
+	branch.Bbalance += adelta
+	if err := branchBucket.Put(keyFor(bid), bn.valueFor(branch)); err != nil {
+		return err
+	}
+
+	if bn.opts.NoHistory {
+		return nil
+	}
+
+	//INSERT INTO pgbench_history (tid, bid, aid, delta, mtime) VALUES (:tid, :bid, :aid, :delta, CURRENT_TIMESTAMP);
+	historyBucket := txn.Bucket(historyPrefix)
+	seq, err := bn.nextHistoryKey(historyBucket)
+	if err != nil {
+		return err
+	}
+	return historyBucket.Put(keyFor(int(seq)), bn.valueFor(History{
+		AID:    int64(accounts[0].aid),
+		TID:    int64(tid),
+		BID:    int64(bid),
+		Delta:  adelta,
+		Mtime:  time.Now(),
+		Filler: bn.historyFiller,
+	}))
+}
+
+// Read is the read-only TPC-B transaction, the account lookup and with
+// -fullread a teller and a branch along with it
+func (bn *Bench) Read(ctx context.Context, rng *Rng) error {
+	aid := rng.ID(bn.opts.Scale * bn.opts.AccountsPerScale)
+	if !bn.opts.FullRead {
+		return bn.db.View(func(txn Txn) error {
+			//SELECT abalance FROM pgbench_accounts WHERE aid = :aid;
+			var acc Account
+			return bn.getRecord(txn.Bucket(accountPrefix), accountPrefix, aid, &acc)
+		})
+	}
+
+	tid := rng.ID(bn.opts.Scale * bn.opts.TellersPerScale)
+	bid := rng.ID(bn.opts.Scale * bn.opts.BranchesPerScale)
+	return bn.db.View(func(txn Txn) error {
+		//SELECT abalance FROM pgbench_accounts WHERE aid = :aid;
+		var acc Account
+		if err := bn.getRecord(txn.Bucket(accountPrefix), accountPrefix, aid, &acc); err != nil {
+			return err
+		}
+		//SELECT tbalance FROM pgbench_tellers WHERE tid = :tid;
+		var teller Teller
+		if err := bn.getRecord(txn.Bucket(tellerPrefix), tellerPrefix, tid, &teller); err != nil {
+			return err
+		}
+		//SELECT bbalance FROM pgbench_branches WHERE bid = :bid;
+		var branch Branche
+		return bn.getRecord(txn.Bucket(branchPrefix), branchPrefix, bid, &branch)
+	})
+}
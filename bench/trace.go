@@ -0,0 +1,103 @@
+package bench
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// traceBatch is the number of events a worker collects before handing them
+// to the writer, so that workers do not meet on every operation
+const traceBatch = 1024
+
+type traceEvent struct {
+	start   time.Time
+	worker  int
+	op      string
+	latency time.Duration
+	key     int
+	failed  bool
+}
+
+// traceLine is one line of -trace. Key is the first id the operation drew,
+// the account of TPC-B operations; it is left out when none was drawn.
+type traceLine struct {
+	Time    time.Time `json:"ts"`
+	Worker  int       `json:"worker"`
+	Op      string    `json:"op"`
+	Latency int64     `json:"latency_ns"`
+	Key     *int      `json:"key,omitempty"`
+	Error   bool      `json:"error,omitempty"`
+}
+
+// Tracer encodes -trace in a goroutine of its own. Workers hand it batches
+// over a buffered channel and only block when it falls behind.
+type Tracer struct {
+	f       *os.File
+	batches chan []traceEvent
+	done    chan error
+}
+
+// OpenTrace creates the trace file at path
+func OpenTrace(path string) (*Tracer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tracer{f: f, batches: make(chan []traceEvent, 64), done: make(chan error, 1)}
+	go t.write()
+	return t, nil
+}
+
+func (t *Tracer) write() {
+	w := bufio.NewWriterSize(t.f, 1<<20)
+	enc := json.NewEncoder(w)
+	var err error
+	for batch := range t.batches {
+		// after a failed write the batches are still drained, so that
+		// workers do not block
+		for _, ev := range batch {
+			if err != nil {
+				break
+			}
+			line := traceLine{Time: ev.start, Worker: ev.worker, Op: ev.op, Latency: int64(ev.latency), Error: ev.failed}
+			if ev.key >= 0 {
+				line.Key = &ev.key
+			}
+			err = enc.Encode(line)
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	t.done <- errors.Join(err, t.f.Close())
+}
+
+// add appends ev to the worker's batch, handing the batch over when full
+func (t *Tracer) add(batch *[]traceEvent, ev traceEvent) {
+	*batch = append(*batch, ev)
+	if len(*batch) >= traceBatch {
+		t.flush(batch)
+	}
+}
+
+func (t *Tracer) flush(batch *[]traceEvent) {
+	if len(*batch) == 0 {
+		return
+	}
+	t.batches <- *batch
+	*batch = make([]traceEvent, 0, traceBatch)
+}
+
+// Close waits for the events handed over so far to be written
+func (t *Tracer) Close() error {
+	close(t.batches)
+	if err := <-t.done; err != nil {
+		return err
+	}
+	slog.Info("wrote trace", "path", t.f.Name())
+	return nil
+}
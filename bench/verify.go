@@ -0,0 +1,64 @@
+package bench
+
+// Balances holds the sum of balances per table. Every tpcb write adds the same
+// total delta to its accounts, one teller and one branch and records it in
+// history,
+// so the three balances always match, and match the history deltas as long
+// as history rows are neither skipped nor deleted. AccountsChange is the
+// change of the account balances during the run, which must equal the
+// Applied deltas of the writes committed in it.
+type Balances struct {
+	Accounts       int64 `json:"accounts"`
+	Tellers        int64 `json:"tellers"`
+	Branches       int64 `json:"branches"`
+	HistoryDelta   int64 `json:"history_delta"`
+	AccountsChange int64 `json:"accounts_change"`
+	Applied        int64 `json:"applied_delta"`
+}
+
+func (b Balances) Imbalance() int64 {
+	return max(abs(b.Tellers-b.Accounts), abs(b.Branches-b.Accounts), abs(b.AccountsChange-b.Applied))
+}
+
+func (b Balances) OK() bool {
+	return b.Imbalance() == 0
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func sumTable[T any](txn Txn, codec Codec, prefix []byte, balance func(rec *T) int64) (int64, error) {
+	var sum int64
+	err := txn.Bucket(prefix).ForEach(func(k, v []byte) error {
+		var rec T
+		if err := codec.Unmarshal(v, &rec); err != nil {
+			return err
+		}
+		sum += balance(&rec)
+		return nil
+	})
+	return sum, err
+}
+
+func (bn *Bench) verifyBalances() (Balances, error) {
+	var rv Balances
+	err := bn.db.View(func(txn Txn) error {
+		var err error
+		if rv.Accounts, err = sumTable(txn, bn.codec, accountPrefix, func(a *Account) int64 { return a.Abalance }); err != nil {
+			return err
+		}
+		if rv.Tellers, err = sumTable(txn, bn.codec, tellerPrefix, func(t *Teller) int64 { return t.Tbalance }); err != nil {
+			return err
+		}
+		if rv.Branches, err = sumTable(txn, bn.codec, branchPrefix, func(b *Branche) int64 { return b.Bbalance }); err != nil {
+			return err
+		}
+		rv.HistoryDelta, err = sumTable(txn, bn.codec, historyPrefix, func(h *History) int64 { return h.Delta })
+		return err
+	})
+	return rv, err
+}
@@ -0,0 +1,443 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/samber/lo"
+	"time"
+)
+
+// OpFunc runs one operation of bn. Long running operations give up with
+// ctx.Err() once ctx is done, so they do not stretch the measured window.
+type OpFunc func(bn *Bench, ctx context.Context, rng *Rng) error
+
+// Workload is a mix of a read and a write operation, either may be nil.
+// ReadOp and WriteOp name the operations in the per-operation breakdown.
+// RowsPerOp is set by workloads reading a fixed number of rows per
+// transaction, to report the per row rate next to the transaction rate.
+// Tables lists the tables the operations touch, Schema describes the custom
+// ones of the schema workload. ReadRecords and WriteRecords are the logical
+// operations, records read, updated, inserted or deleted, of one read and
+// one write transaction, the mean where it varies; zero means one.
+type Workload struct {
+	Name      string
+	Read      OpFunc
+	Write     OpFunc
+	ReadOp    string
+	WriteOp   string
+	ReadPct   int
+	RowsPerOp int
+	Tables    [][]byte
+	Schema    []SchemaBucket
+
+	ReadRecords  float64
+	WriteRecords float64
+}
+
+// records returns the logical operations of a read or write transaction
+func (w Workload) records(isRead bool) float64 {
+	n := lo.Ternary(isRead, w.ReadRecords, w.WriteRecords)
+	return lo.Ternary(n == 0, 1, n)
+}
+
+// Tables returns the tables a run of workloads needs: the ones of the
+// workloads and the ones the checks enabled by o read, in allTables order
+// followed by the custom ones of their Schema
+func (o Options) Tables(workloads ...Workload) [][]byte {
+	var needed [][]byte
+	var schema []SchemaBucket
+	for _, w := range workloads {
+		needed = append(needed, w.Tables...)
+		schema = lo.Ternary(w.Schema != nil, w.Schema, schema)
+	}
+	if o.Verify {
+		needed = append(needed, allTables...)
+	}
+	if o.CheckHistory || o.CompactHistory {
+		needed = append(needed, historyPrefix)
+	}
+	builtin := lo.Filter(allTables, func(t []byte, _ int) bool { return hasTable(needed, t) })
+	return append(builtin, schemaTables(schema)...)
+}
+
+// isReader reports whether worker belongs to the read pool, which follows
+// the write pool of writers workers
+func isReader(worker, writers int) bool {
+	return worker >= writers
+}
+
+// readShare is the percentage of operations of worker that are reads, with
+// a write pool of writers workers
+func (w Workload) readShare(worker, writers int) int {
+	switch {
+	case w.Write == nil:
+		return 100
+	case w.Read == nil:
+		return 0
+	case writers > 0:
+		return lo.Ternary(isReader(worker, writers), 100, 0)
+	default:
+		return w.ReadPct
+	}
+}
+
+func (w Workload) pick(worker, writers int, rng *Rng) (op OpFunc, isRead bool) {
+	switch {
+	case w.Write == nil:
+		return w.Read, true
+	case w.Read == nil:
+		return w.Write, false
+	case writers > 0:
+		return lo.Ternary(isReader(worker, writers), w.Read, w.Write), isReader(worker, writers)
+	case rng.IntN(100) < w.ReadPct:
+		return w.Read, true
+	default:
+		return w.Write, false
+	}
+}
+
+// SelectWorkload returns the workload name, shaped by the options of o that
+// change what it runs
+func SelectWorkload(o Options, name string) (Workload, error) {
+	accounts := [][]byte{accountPrefix}
+	history := [][]byte{historyPrefix}
+	switch name {
+	case "tpcb":
+		// runs without history inserts are not comparable to ones with them
+		suffix := lo.Ternary(o.NoHistory, "-nohistory", "")
+		suffix += lo.Ternary(o.WritesPerTxn > 1, fmt.Sprintf("-x%d", o.WritesPerTxn), "")
+		// report the rate of account updates next to the rate of commits
+		rows := lo.Ternary(o.WritesPerTxn > 1, o.WritesPerTxn, 0)
+		// the account updates, one update each of a teller and branch and the
+		// history insert
+		writeRecords := float64(o.WritesPerTxn + lo.Ternary(o.NoHistory, 2, 3))
+		readRecords := lo.Ternary(o.FullRead, 3.0, 1.0)
+		writeTables := lo.Ternary(o.NoHistory, allTables[:3], allTables)
+		readTables := lo.Ternary(o.FullRead, allTables[:3], accounts)
+		if o.ReadPct >= 0 || o.pools() {
+			return Workload{Name: "tpcb-mixed" + suffix, Read: (*Bench).Read, Write: (*Bench).ReadWrite, ReadOp: "read", WriteOp: "readWrite", ReadPct: o.ReadPct, Tables: writeTables,
+				ReadRecords: readRecords, WriteRecords: writeRecords}, nil
+		}
+		if o.RWMode {
+			return Workload{Name: "tpcb-like" + suffix, Write: (*Bench).ReadWrite, WriteOp: "readWrite", RowsPerOp: rows, Tables: writeTables, WriteRecords: writeRecords}, nil
+		}
+		return Workload{Name: lo.Ternary(o.FullRead, "tpcb-fullread", "tpcb-readonly"), Read: (*Bench).Read, ReadOp: "read", Tables: readTables, ReadRecords: readRecords}, nil
+	case "blind-write":
+		return Workload{Name: "blind-write" + lo.Ternary(o.NoHistory, "-nohistory", ""), Write: (*Bench).blindWrite, WriteOp: "blindWrite",
+			Tables: lo.Ternary(o.NoHistory, allTables[:3], allTables), WriteRecords: lo.Ternary(o.NoHistory, 3.0, 4.0)}, nil
+	case "scan":
+		return Workload{Name: "scan", Read: (*Bench).scan, ReadOp: "scan", Tables: accounts, ReadRecords: float64(o.ScanLen)}, nil
+	case "multiread":
+		return Workload{Name: "multiread", Read: (*Bench).multiread, ReadOp: "multiread", RowsPerOp: o.MultireadKeys, Tables: accounts, ReadRecords: float64(o.MultireadKeys)}, nil
+	case "append":
+		return Workload{Name: "append", Write: (*Bench).appendHistory, WriteOp: "append", Tables: history}, nil
+	case "point-read":
+		return Workload{Name: "point-read", Read: (*Bench).pointRead, ReadOp: "point-read", Tables: accounts}, nil
+	case "sequential-read":
+		return Workload{Name: "sequential-read", Read: (*Bench).sequentialRead, ReadOp: "sequential-read", Tables: accounts}, nil
+	case "ryw":
+		return Workload{Name: "ryw", Write: (*Bench).readYourWrites, WriteOp: "ryw", Tables: accounts, WriteRecords: 4}, nil
+	case "churn":
+		return Workload{Name: "churn", Write: (*Bench).churn, WriteOp: "churn", Tables: history, WriteRecords: 2}, nil
+	case "dashboard":
+		return Workload{Name: lo.Ternary(o.DashboardDecode, "dashboard", "dashboard-raw"), Read: (*Bench).dashboard, ReadOp: "dashboard", Tables: [][]byte{accountPrefix, tellerPrefix, historyPrefix},
+			ReadRecords: 2 + float64(o.DashboardHistory)/2}, nil
+	case "schema":
+		if len(o.Schema) == 0 {
+			return Workload{}, errors.New("the schema workload needs -schema")
+		}
+		return schemaWorkload(o), nil
+	default:
+		return Workload{}, fmt.Errorf("unknown workload %q", name)
+	}
+}
+
+func (bn *Bench) scan(ctx context.Context, rng *Rng) error {
+	aid := rng.ID(bn.opts.Scale * bn.opts.AccountsPerScale)
+	return bn.db.View(func(txn Txn) error {
+		//SELECT * FROM pgbench_accounts WHERE aid >= :aid LIMIT :scanlen;
+		c := txn.Bucket(accountPrefix).Cursor()
+		n := 0
+		for k, v := c.Seek(keyFor(aid)); k != nil && n < bn.opts.ScanLen; k, v = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var acc Account
+			if err := bn.codec.Unmarshal(v, &acc); err != nil {
+				id, _ := idFor(k)
+				return fmt.Errorf("%s%d: %w", accountPrefix, id, err)
+			}
+			n++
+		}
+		return nil
+	})
+}
+
+// multiread reads -multiread-keys random accounts in a single transaction,
+// amortizing the transaction setup over several lookups
+func (bn *Bench) multiread(ctx context.Context, rng *Rng) error {
+	accounts, _, _ := bn.opts.tableSizes()
+	return bn.db.View(func(txn Txn) error {
+		b := txn.Bucket(accountPrefix)
+		for range bn.opts.MultireadKeys {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			//SELECT abalance FROM pgbench_accounts WHERE aid = :aid;
+			var acc Account
+			if err := bn.getRecord(b, accountPrefix, rng.ID(accounts), &acc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// appendHistory is the history insert of tpcb on its own, a pure sequential
+// write. The record carries no delta so that -verify still balances.
+func (bn *Bench) appendHistory(ctx context.Context, rng *Rng) error {
+	aid := rng.ID(bn.opts.Scale * bn.opts.AccountsPerScale)
+	return bn.db.Update(func(txn Txn) error {
+		//INSERT INTO pgbench_history (tid, bid, aid, delta, mtime) VALUES (:tid, :bid, :aid, 0, CURRENT_TIMESTAMP);
+		historyBucket := txn.Bucket(historyPrefix)
+		seq, err := bn.nextHistoryKey(historyBucket)
+		if err != nil {
+			return err
+		}
+		return historyBucket.Put(keyFor(int(seq)), bn.valueFor(History{
+			AID:    int64(aid),
+			Mtime:  time.Now(),
+			Filler: bn.historyFiller,
+		}))
+	})
+}
+
+// pointRead is the account lookup of tpcb on its own
+func (bn *Bench) pointRead(ctx context.Context, rng *Rng) error {
+	aid := rng.ID(bn.opts.Scale * bn.opts.AccountsPerScale)
+	return bn.db.View(func(txn Txn) error {
+		//SELECT abalance FROM pgbench_accounts WHERE aid = :aid;
+		var acc Account
+		return bn.getRecord(txn.Bucket(accountPrefix), accountPrefix, aid, &acc)
+	})
+}
+
+// sequentialRead reads the next account of the worker in cursor order, the
+// best case of locality against which random reads compare. The accounts are
+// split into one contiguous range per worker, so that workers do not share
+// pages, and each worker wraps around at the end of its range.
+func (bn *Bench) sequentialRead(ctx context.Context, rng *Rng) error {
+	accounts, _, _ := bn.opts.tableSizes()
+	workers := max(rng.workers, 1)
+	start, end := accounts*rng.worker/workers, accounts*(rng.worker+1)/workers
+	if end <= start {
+		start, end = 0, accounts
+	}
+	if rng.walk < start || rng.walk >= end {
+		rng.walk = start
+	}
+	return bn.db.View(func(txn Txn) error {
+		//SELECT abalance FROM pgbench_accounts WHERE aid >= :aid ORDER BY aid LIMIT 1;
+		c := txn.Bucket(accountPrefix).Cursor()
+		k, v := c.Seek(keyFor(rng.walk))
+		if k != nil && bytes.Compare(k, keyFor(end)) >= 0 {
+			k = nil
+		}
+		if k == nil {
+			// past the last account of the range, wrap around
+			if k, v = c.Seek(keyFor(start)); k == nil {
+				return fmt.Errorf("%s%d: %w", accountPrefix, start, errNotFound)
+			}
+		}
+		id, err := idFor(k)
+		if err != nil {
+			return err
+		}
+		rng.noteID(id)
+		rng.walk = id + 1
+		var acc Account
+		if err := bn.codec.Unmarshal(v, &acc); err != nil {
+			return fmt.Errorf("%s%d: %w", accountPrefix, id, err)
+		}
+		return nil
+	})
+}
+
+// readYourWrites moves a random amount between two accounts and reads both
+// back in a separate transaction, failing when the read does not return the
+// written balances. Moving rather than setting balances keeps -verify
+// meaningful. Accounts are picked with OwnID, so another worker writing them
+// in between cannot cause a false mismatch.
+func (bn *Bench) readYourWrites(ctx context.Context, rng *Rng) error {
+	accounts, _, _ := bn.opts.tableSizes()
+	aids := [2]int{rng.OwnID(accounts), rng.OwnID(accounts)}
+	delta := bn.randomDelta(rng)
+	var want [2]int64
+	err := bn.db.Update(func(txn Txn) error {
+		//UPDATE pgbench_accounts SET abalance = abalance + :delta WHERE aid = :aid1;
+		//UPDATE pgbench_accounts SET abalance = abalance - :delta WHERE aid = :aid2;
+		b := txn.Bucket(accountPrefix)
+		for i, aid := range aids {
+			var acc Account
+			if err := bn.getRecord(b, accountPrefix, aid, &acc); err != nil {
+				return err
+			}
+			acc.Abalance += lo.Ternary(i == 0, delta, -delta)
+			if err := b.Put(keyFor(aid), bn.valueFor(acc)); err != nil {
+				return err
+			}
+			want[i] = acc.Abalance
+		}
+		// both picks may be the same account, the second update then
+		// undoes the first
+		want[0] = lo.Ternary(aids[0] == aids[1], want[1], want[0])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bn.db.View(func(txn Txn) error {
+		//SELECT abalance FROM pgbench_accounts WHERE aid IN (:aid1, :aid2);
+		b := txn.Bucket(accountPrefix)
+		for i, aid := range aids {
+			var acc Account
+			if err := bn.getRecord(b, accountPrefix, aid, &acc); err != nil {
+				return err
+			}
+			if acc.Abalance != want[i] {
+				return fmt.Errorf("%s%d: read balance %d after writing %d", accountPrefix, aid, acc.Abalance, want[i])
+			}
+		}
+		return nil
+	})
+}
+
+// churn appends a history record and deletes the one -churn-window records
+// older, so the bucket keeps its size while pages are constantly freed and
+// reused
+func (bn *Bench) churn(ctx context.Context, rng *Rng) error {
+	aid := rng.ID(bn.opts.Scale * bn.opts.AccountsPerScale)
+	return bn.db.Update(func(txn Txn) error {
+		historyBucket := txn.Bucket(historyPrefix)
+		seq, err := bn.nextHistoryKey(historyBucket)
+		if err != nil {
+			return err
+		}
+		err = historyBucket.Put(keyFor(int(seq)), bn.valueFor(History{
+			AID:    int64(aid),
+			Mtime:  time.Now(),
+			Filler: bn.historyFiller,
+		}))
+		if err != nil {
+			return err
+		}
+		if seq <= uint64(bn.opts.ChurnWindow) {
+			return nil
+		}
+		return historyBucket.Delete(keyFor(int(seq) - bn.opts.ChurnWindow))
+	})
+}
+
+// dashboard reads an account, a teller and a random number of the newest
+// history records in one transaction, like a dashboard showing recent
+// activity. The history scan walks the ever growing bucket backward from its
+// end. Without -dashboard-decode the values are only fetched.
+func (bn *Bench) dashboard(ctx context.Context, rng *Rng) error {
+	aid := rng.ID(bn.opts.Scale * bn.opts.AccountsPerScale)
+	tid := rng.ID(bn.opts.Scale * bn.opts.TellersPerScale)
+	n := rng.IntN(bn.opts.DashboardHistory + 1)
+	return bn.db.View(func(txn Txn) error {
+		//SELECT abalance FROM pgbench_accounts WHERE aid = :aid;
+		//SELECT tbalance FROM pgbench_tellers WHERE tid = :tid;
+		var acc Account
+		var teller Teller
+		if err := bn.dashboardGet(txn.Bucket(accountPrefix), accountPrefix, aid, &acc); err != nil {
+			return err
+		}
+		if err := bn.dashboardGet(txn.Bucket(tellerPrefix), tellerPrefix, tid, &teller); err != nil {
+			return err
+		}
+		//SELECT * FROM pgbench_history ORDER BY mtime DESC LIMIT :n;
+		c := txn.Bucket(historyPrefix).Cursor()
+		i := 0
+		for k, v := c.Last(); k != nil && i < n; k, v = c.Prev() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if bn.opts.DashboardDecode {
+				var h History
+				if err := bn.codec.Unmarshal(v, &h); err != nil {
+					id, _ := idFor(k)
+					return fmt.Errorf("%s%d: %w", historyPrefix, id, err)
+				}
+			}
+			i++
+		}
+		return nil
+	})
+}
+
+// dashboardGet is getRecord, skipping the decoding without -dashboard-decode
+func (bn *Bench) dashboardGet(b Bucket, prefix []byte, id int, val interface{}) error {
+	if bn.opts.DashboardDecode {
+		return bn.getRecord(b, prefix, id, val)
+	}
+	if b.Get(keyFor(id)) == nil {
+		return fmt.Errorf("%s%d: %w", prefix, id, errNotFound)
+	}
+	return nil
+}
+
+// blindWrite writes the rows of a tpcb transaction without reading them
+// first, freshly generated with the delta as the balance, so that the
+// difference to tpcb is the cost of the reads and their decoding. It
+// overwrites balances, which is why -verify does not apply.
+func (bn *Bench) blindWrite(ctx context.Context, rng *Rng) error {
+	aid := rng.ID(bn.opts.Scale * bn.opts.AccountsPerScale)
+	tid := rng.ID(bn.opts.Scale * bn.opts.TellersPerScale)
+	bid := rng.ID(bn.opts.Scale * bn.opts.BranchesPerScale)
+	adelta := bn.randomDelta(rng)
+	acc := bn.valueFor(Account{AID: aid, Abalance: adelta, Filler: bn.newFiller(rng, bn.opts.fillSizeOf(bn.opts.FillSizeAccounts))})
+	teller := bn.valueFor(Teller{TID: tid, Tbalance: adelta, Filler: bn.newFiller(rng, bn.opts.fillSizeOf(bn.opts.FillSizeTellers))})
+	branch := bn.valueFor(Branche{BID: bid, Bbalance: adelta, Filler: bn.newFiller(rng, bn.opts.fillSizeOf(bn.opts.FillSizeBranches))})
+	var failed error
+	update := func(txn Txn) error {
+		failed = bn.blindWriteTxn(txn, aid, tid, bid, adelta, acc, teller, branch)
+		return failed
+	}
+	return bn.commitWithRetry(ctx, lo.Ternary(bn.opts.Batch, bn.db.Batch, bn.db.Update), update, func() bool { return failed == nil }, rng)
+}
+
+func (bn *Bench) blindWriteTxn(txn Txn, aid, tid, bid int, adelta int64, acc, teller, branch []byte) error {
+	//UPDATE pgbench_accounts SET abalance = :delta WHERE aid = :aid;
+	if err := txn.Bucket(accountPrefix).Put(keyFor(aid), acc); err != nil {
+		return err
+	}
+	//UPDATE pgbench_tellers SET tbalance = :delta WHERE tid = :tid;
+	if err := txn.Bucket(tellerPrefix).Put(keyFor(tid), teller); err != nil {
+		return err
+	}
+	//UPDATE pgbench_branches SET bbalance = :delta WHERE bid = :bid;
+	if err := txn.Bucket(branchPrefix).Put(keyFor(bid), branch); err != nil {
+		return err
+	}
+	if bn.opts.NoHistory {
+		return nil
+	}
+	//INSERT INTO pgbench_history (tid, bid, aid, delta, mtime) VALUES (:tid, :bid, :aid, :delta, CURRENT_TIMESTAMP);
+	historyBucket := txn.Bucket(historyPrefix)
+	seq, err := bn.nextHistoryKey(historyBucket)
+	if err != nil {
+		return err
+	}
+	return historyBucket.Put(keyFor(int(seq)), bn.valueFor(History{
+		AID:    int64(aid),
+		TID:    int64(tid),
+		BID:    int64(bid),
+		Delta:  adelta,
+		Mtime:  time.Now(),
+		Filler: bn.historyFiller,
+	}))
+}
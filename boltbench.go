@@ -2,266 +2,304 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/boltdb/bolt"
-	"github.com/olekukonko/tablewriter"
+	"github.com/ivagulin/boltbench/bench"
 	"github.com/samber/lo"
 	"log"
 	"log/slog"
-	"math/rand/v2"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"strconv"
-	"sync"
-	"sync/atomic"
+	"strings"
+	"syscall"
 	"time"
 )
 
+// The database at -db is reused between runs. With -init (the default) each
+// bucket is topped up to the row counts implied by -scale, and filling is
+// skipped for buckets that already have them, so a pre-built database is never
+// rewritten. With -init=false the database is used as is; -scale must then not
+// exceed the scale the database was filled with, since it bounds the ids the
+// workload picks.
 var (
-	concurrency = flag.Int("concurrency", 24, "Number of concurrent goroutines")
-	benchtime   = flag.Duration("benchtime", 60*time.Second, "Bench time")
-	scale       = flag.Int("scale", 1000, "Scaling factor")
-	RWMode      = flag.Bool("rwmode", true, "Read write mode")
-	initMode    = flag.Bool("init", true, "init")
+	concurrency      = flag.Int("concurrency", 24, "Number of concurrent goroutines")
+	readWorkers      = flag.Int("read-workers", 0, "Run this many workers doing only reads next to -write-workers doing only writes, replacing -concurrency and -readpct")
+	writeWorkers     = flag.Int("write-workers", 0, "Run this many workers doing only writes next to -read-workers doing only reads")
+	concurrencySweep = flag.String("concurrency-sweep", "", "Comma separated concurrency levels to run one after another, overrides -concurrency")
+	rateSweep        = flag.String("rate-sweep", "", "Comma separated -rate values in ops/sec to run one after another at -concurrency, e.g. 100,500,1000, printing latency against load")
+	sweepSettle      = flag.Duration("sweep-settle", 2*time.Second, "Delay between sweep points")
+	benchtime        = flag.Duration("benchtime", 60*time.Second, "Bench time")
+	nops             = flag.Uint64("nops", 0, "Stop after this many operations across all workers instead of after -benchtime")
+	warmup           = flag.Duration("warmup", 0, "Warmup time before measurement starts")
+	calibration      = flag.Duration("calibration", 500*time.Millisecond, "Minimum warmup, used to estimate the op rate and preallocate latency buffers")
+	scale            = flag.Int("scale", 1000, "Scaling factor, each unit adds -accounts-per-scale accounts, -tellers-per-scale tellers and -branches-per-scale branches")
+	accountsPerScale = flag.Int("accounts-per-scale", 100_000, "Accounts per -scale unit, 100000 like pgbench")
+	tellersPerScale  = flag.Int("tellers-per-scale", 10, "Tellers per -scale unit, 10 like pgbench")
+	branchesPerScale = flag.Int("branches-per-scale", 1, "Branches per -scale unit, 1 like pgbench")
+	RWMode           = flag.Bool("rwmode", true, "Read write mode")
+	initMode         = flag.Bool("init", true, "Fill the database up to -scale before benchmarking")
+	dbPath           = flag.String("db", "my.db", "Path to the database file")
+	tmpDB            = flag.Bool("tmpdb", false, "Use a temporary database file in $TMPDIR, deleted on exit, instead of -db")
+	cleanup          = flag.Bool("cleanup", false, "Delete the database file on exit if this run created it, a file that existed before is kept")
+	output           = flag.String("output", "table", "Output format: table, json or oneline")
+	backend          = flag.String("backend", "bolt", "Storage backend")
+	fillBatchSize    = flag.Int("fill-batch", 1000, "Number of records written per fill transaction")
+	fillSize         = flag.Int("fillsize", 0, "Size of filler payload in bytes, default of the per record -fillsize-* flags")
+	workload         = flag.String("workload", "tpcb", "Workload: tpcb (see -rwmode), scan, multiread, append, point-read, sequential-read, ryw, churn, dashboard, blind-write or schema (see -schema)")
+	scanLen          = flag.Int("scanlen", 100, "Number of accounts read per scan iteration")
+	multireadKeys    = flag.Int("multiread-keys", 10, "Number of accounts read per multiread transaction")
+	churnWindow      = flag.Int("churn-window", 100_000, "Number of history records kept by the churn workload")
+	deltaMin         = flag.Int64("delta-min", -5000, "Smallest balance change of a tpcb or ryw update, inclusive")
+	deltaMax         = flag.Int64("delta-max", 4999, "Largest balance change of a tpcb or ryw update, inclusive")
+	writesPerTxn     = flag.Int("writes-per-txn", 1, "Number of accounts updated per tpcb write transaction, next to one teller, branch and history row taking the sum of their deltas")
+	dashboardHistory = flag.Int("dashboard-history", 10, "Maximum number of newest history records read per dashboard transaction, each reads a random count from 0 to this")
+	dashboardDecode  = flag.Bool("dashboard-decode", true, "Deserialize the records read by the dashboard workload, false only fetches the raw values")
+	maxRetries       = flag.Int("max-retries", 10, "Retries of a failed write transaction before it counts as an error")
+	retryBackoff     = flag.Duration("retry-backoff", time.Millisecond, "Initial backoff between write retries, doubled on every retry and jittered")
+	retryBackoffMax  = flag.Duration("retry-backoff-max", 100*time.Millisecond, "Upper bound of the backoff between write retries")
+	batchMode        = flag.Bool("batch", false, "Commit writes through Batch so concurrent transactions can be coalesced")
+	dryRun           = flag.Bool("dryrun", false, "Print the fill plan and effective flags, then exit without touching the database")
+	encoding         = flag.String("encoding", "json", "Value encoding: json, gob or binary; the database must be filled with the same encoding")
+	httpAddr         = flag.String("http-addr", "localhost:6060", "Listen address for the pprof and /metrics server, empty disables it")
+	bucketStats      = flag.Bool("bucket-stats", true, "Report per bucket tree statistics after the run")
+	verify           = flag.Bool("verify", false, "Check after the run that account, teller and branch balances add up")
+	checkHist        = flag.Bool("check-history", false, "Verify that every history sequence number handed out during the run added a row, not meaningful for churn which deletes rows")
+	compareWith      = flag.String("compare", "", "Run a second configuration B after the first one, given as space separated flag overrides, e.g. \"db=b.db nosync=true\"")
+	csvPath          = flag.String("csv", "", "Append a summary row to this CSV file")
+	noHistory        = flag.Bool("no-history", false, "Skip the history insert of tpcb write transactions")
+	fullRead         = flag.Bool("fullread", false, "Read a teller and a branch along with the account in read-only tpcb")
+	summaryPath      = flag.String("summary", "", "Write a JSON summary with flags, build info and all metrics to this file")
+	readPct          = flag.Int("readpct", -1, "Percentage of tpcb iterations that are reads, overrides -rwmode when set")
+	rate             = flag.Float64("rate", 0, "Target aggregate rate in ops/sec, 0 runs flat out")
+	latencyUnitName  = flag.String("latency-unit", "us", "Unit of the latencies in the tables: ns, us, ms, or auto to pick one by their magnitude")
+	sampleRate       = flag.Float64("latency-sample-rate", 1, "Fraction of operations timed for the latency figures and histogram, picked at random, while throughput counts all; percentiles then describe the sample, which stays unbiased but makes the far tail noisier")
+	latencyWindow    = flag.Duration("latency-window", 10*time.Second, "Also report latency per window of this length, to show it changing over the run; 0 disables it")
+	reportEvery      = flag.Duration("report-interval", 5*time.Second, "Interval between progress reports, 0 disables them")
 )
 
-var (
-	accountPrefix = []byte("accounts:")
-	tellerPrefix  = []byte("tellers:")
-	branchPrefix  = []byte("branches:")
-	historyPrefix = []byte("history:")
-)
-
-type Account struct {
-	AID      int    `db:"aid"`
-	BID      int64  `db:"bid"`
-	Abalance int64  `db:"abalance"`
-	Filler   string `db:"filler"`
-}
-
-type Teller struct {
-	TID      int    `db:"tid"`
-	BID      int64  `db:"bid"`
-	Tbalance int64  `db:"tbalance"`
-	Filler   string `db:"filler"`
+// sweepPoint is the concurrency and target rate of one measured window
+type sweepPoint struct {
+	concurrency int
+	rate        float64
 }
 
-type Branche struct {
-	BID      int    `db:"bid"`
-	Bbalance int64  `db:"bbalance"`
-	Filler   string `db:"filler"`
-}
-
-type History struct {
-	TID    int64     `db:"tid"`
-	BID    int64     `db:"bid"`
-	AID    int64     `db:"aid"`
-	Delta  int64     `db:"delta"`
-	Mtime  time.Time `db:"mtime"`
-	Filler string    `db:"filler"`
-}
-
-func keyFor(id int) []byte {
-	return []byte(strconv.Itoa(id))
+// sweepPoints returns the windows of -concurrency-sweep or -rate-sweep, or
+// the single one of -concurrency, or of the worker pools, and -rate
+func sweepPoints() ([]sweepPoint, error) {
+	if *concurrencySweep != "" && *rateSweep != "" {
+		return nil, errors.New("-concurrency-sweep and -rate-sweep cannot be combined")
+	}
+	if *singleWriter && (*readWorkers > 0 || *writeWorkers > 0) {
+		return nil, errors.New("-single-writer cannot be combined with -read-workers and -write-workers")
+	}
+	if *singleWriter && *rateSweep != "" {
+		return nil, errors.New("-single-writer cannot be combined with -rate-sweep")
+	}
+	if pools() && !*singleWriter && (*concurrencySweep != "" || *rateSweep != "") {
+		return nil, errors.New("-read-workers and -write-workers cannot be combined with a sweep")
+	}
+	var points []sweepPoint
+	switch {
+	case *concurrencySweep != "":
+		for _, f := range strings.Split(*concurrencySweep, ",") {
+			c, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil || c <= 0 {
+				return nil, fmt.Errorf("invalid -concurrency-sweep value %q", f)
+			}
+			// the writer of -single-writer comes on top of the readers
+			points = append(points, sweepPoint{concurrency: c + lo.Ternary(*singleWriter, 1, 0), rate: *rate})
+		}
+	case *rateSweep != "":
+		for _, f := range strings.Split(*rateSweep, ",") {
+			r, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+			if err != nil || r <= 0 {
+				return nil, fmt.Errorf("invalid -rate-sweep value %q", f)
+			}
+			points = append(points, sweepPoint{concurrency: *concurrency, rate: r})
+		}
+	case *singleWriter:
+		points = append(points, sweepPoint{concurrency: *concurrency + 1, rate: *rate})
+	case pools():
+		points = append(points, sweepPoint{concurrency: *readWorkers + *writeWorkers, rate: *rate})
+	default:
+		points = append(points, sweepPoint{concurrency: *concurrency, rate: *rate})
+	}
+	return points, nil
 }
 
-func valueFor(val interface{}) []byte {
-	rv, err := json.Marshal(val)
+// runConfig runs the workload, or each of -phases in turn, once per sweep
+// point against the same database
+func runConfig() ([]bench.Result, error) {
+	opts, err := benchOptions()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return rv
-}
-
-func fillTable(db *bolt.DB, prefix []byte, limit int, genfunc func(it int) interface{}) {
-	created := 0
-	err := db.View(func(txn *bolt.Tx) error {
-		b := txn.Bucket(prefix)
-		c := b.Cursor()
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			created++
-		}
-		return nil
-	})
+	workloads, err := selectPhases(opts)
+	if err != nil {
+		return nil, err
+	}
+	points, err := sweepPoints()
+	if err != nil {
+		return nil, err
+	}
+	bn, err := bench.Open(opts, opts.Tables(workloads...))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer bn.Close()
+	setCurrent(bn)
+	defer setCurrent(nil)
 
-	for created < limit {
-		slog.Info("filling table", "prefix", prefix, "limit", limit, "created", created)
-		err = db.Update(func(txn *bolt.Tx) error {
-			b := txn.Bucket(prefix)
-			for it := created; it < limit && it-created < 1000; it++ {
-				val := genfunc(it)
-				b.Put(keyFor(it), valueFor(val))
+	run := runOptions()
+	var results []bench.Result
+	for phase, wl := range workloads {
+		for _, p := range points {
+			if len(results) > 0 && *sweepSettle > 0 {
+				slog.Info("settling...", "delay", *sweepSettle)
+				time.Sleep(*sweepSettle)
+			}
+			if *phases != "" {
+				slog.Info("phase", "phase", phase+1, "workload", wl.Name)
+			}
+			run.Concurrency, run.Rate = p.concurrency, p.rate
+			var extra []bench.Probe
+			if *cpuProfile != "" || *memProfile != "" {
+				extra = append(extra, &profiler{})
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			result, err := bn.Measure(ctx, wl, run, extra...)
+			stop()
+			if err != nil {
+				return results, err
+			}
+			result.Phase = lo.Ternary(*phases != "", phase+1, 0)
+			results = append(results, result)
+			if result.Interrupted {
+				return results, nil
 			}
-			created += 1000
-			return nil
-		})
-		if err != nil {
-			panic(err)
 		}
 	}
+	return results, nil
 }
 
-func fill(db *bolt.DB) {
-	accountsToCreate := *scale * 100_000
-	tellersToCreate := *scale * 10
-	branchesToCreate := *scale * 1
-
-	fillTable(db, accountPrefix, accountsToCreate, func(it int) interface{} {
-		return Account{AID: it}
-	})
-
-	fillTable(db, tellerPrefix, tellersToCreate, func(it int) interface{} {
-		return Teller{TID: it}
-	})
-
-	fillTable(db, branchPrefix, branchesToCreate, func(it int) interface{} {
-		return Branche{BID: it}
-	})
+// removeIfCreated returns a func deleting path under -cleanup, or doing
+// nothing if path already exists now and so was not created by this run
+func removeIfCreated(path string) func() {
+	if _, err := os.Stat(path); !*cleanup || err == nil {
+		return func() {}
+	}
+	return func() {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("could not remove database", "db", path, "err", err)
+			return
+		}
+		slog.Info("removed database", "db", path)
+	}
 }
 
-func readWrite(db *bolt.DB) {
-	aid := rand.IntN(*scale * 100_000)
-	tid := rand.IntN(*scale * 10)
-	bid := rand.IntN(*scale * 1)
-	adelta := rand.Int64N(10000) - 5000
-	err := db.Update(func(txn *bolt.Tx) error {
-		//SELECT abalance FROM pgbench_accounts WHERE aid = :aid;
-		accBucket := txn.Bucket(accountPrefix)
-		accVal := accBucket.Get(keyFor(aid))
-		if accVal == nil {
-			panic("account not found for key")
-		}
-		var acc Account
-		lo.Must0(json.Unmarshal(accVal, &acc))
+func run(flags map[string]string, start time.Time) error {
+	if *dryRun {
+		return writePlan(os.Stdout)
+	}
 
-		//UPDATE pgbench_accounts SET abalance = abalance + :delta WHERE aid = :aid;
-		acc.Abalance += adelta
-		accBucket.Put(keyFor(aid), valueFor(acc))
+	if *tmpDB {
+		f, err := os.CreateTemp("", "boltbench-*.db")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+		*dbPath = f.Name()
+		slog.Info("using temporary database", "db", *dbPath)
+	}
+	defer removeIfCreated(*dbPath)()
 
-		//UPDATE pgbench_tellers SET tbalance = tbalance + :delta WHERE tid = :tid;
-		tellerBucket := txn.Bucket(tellerPrefix)
-		tellerVal := tellerBucket.Get(keyFor(tid))
-		if tellerVal == nil {
-			panic("teller not found for key")
+	if *httpAddr != "" {
+		// listen before starting the run so that a port in use fails it
+		ln, err := net.Listen("tcp", *httpAddr)
+		if err != nil {
+			return fmt.Errorf("-http-addr: %w", err)
 		}
-		var teller Teller
-		lo.Must0(json.Unmarshal(tellerVal, &teller))
-		teller.Tbalance += adelta
-		tellerBucket.Put(keyFor(tid), valueFor(teller))
+		http.HandleFunc("/metrics", serveMetrics)
+		go func() {
+			log.Println(http.Serve(ln, nil))
+		}()
+	}
 
-		//UPDATE pgbench_branches SET bbalance = bbalance + :delta WHERE bid = :bid;
-		branchBucket := txn.Bucket(branchPrefix)
-		branchVal := branchBucket.Get(keyFor(bid))
-		if branchVal == nil {
-			panic("branch not found for key")
+	if *tracePath != "" {
+		t, err := bench.OpenTrace(*tracePath)
+		if err != nil {
+			return fmt.Errorf("-trace: %w", err)
 		}
-		var branch Branche
-		lo.Must0(json.Unmarshal(branchVal, &branch))
-		branch.Bbalance += adelta
-		branchBucket.Put(keyFor(tid), valueFor(branch))
+		opTrace = t
+		defer func() {
+			if err := t.Close(); err != nil {
+				slog.Error("writing trace failed", "path", *tracePath, "err", err)
+			}
+		}()
+	}
 
-		//INSERT INTO pgbench_history (tid, bid, aid, delta, mtime) VALUES (:tid, :bid, :aid, :delta, CURRENT_TIMESTAMP);
-		historyBucket := txn.Bucket(historyPrefix)
-		historyBucket.Put(keyFor(int(lo.Must(historyBucket.NextSequence()))), valueFor(History{
-			AID:   int64(aid),
-			TID:   int64(tid),
-			BID:   int64(bid),
-			Delta: adelta,
-			Mtime: time.Now(),
-		}))
-		return nil
-	})
+	results, err := runConfig()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if *compareWith != "" && !results[len(results)-1].Interrupted {
+		for i := range results {
+			results[i].Label = "A"
+		}
+		if err := applyOverrides(*compareWith); err != nil {
+			return err
+		}
+		defer removeIfCreated(*dbPath)()
+		if err := validateFlags(); err != nil {
+			return fmt.Errorf("-compare: %w", err)
+		}
+		resultsB, err := runConfig()
+		if err != nil {
+			return err
+		}
+		for i := range resultsB {
+			resultsB[i].Label = "B"
+		}
+		results = append(results, resultsB...)
 	}
-}
 
-func read(db *bolt.DB) {
-	aid := rand.IntN(*scale * 100_000)
-	err := db.View(func(txn *bolt.Tx) error {
-		//SELECT abalance FROM pgbench_accounts WHERE aid = :aid;
-		accBucket := txn.Bucket(accountPrefix)
-		accVal := accBucket.Get(keyFor(aid))
-		if accVal == nil {
-			panic("account not found for key")
+	logResults(results)
+	if err := writeResults(os.Stdout, flags, results); err != nil {
+		return err
+	}
+	if *csvPath != "" {
+		if err := appendCSV(*csvPath, results); err != nil {
+			return err
+		}
+	}
+	if *summaryPath != "" {
+		if err := writeSummary(*summaryPath, flags, start, results); err != nil {
+			return err
 		}
-		var acc Account
-		lo.Must0(json.Unmarshal(accVal, &acc))
-		return nil
-	})
-	if err != nil {
-		panic(err)
 	}
+	return checkSLA(results)
 }
 
 func main() {
+	start := time.Now()
 	flag.Parse()
-
-	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
-	}()
-
-	db, err := bolt.Open("my.db", 0600, nil)
-	if err != nil {
+	if err := loadConfig(); err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
-
-	db.Update(func(tx *bolt.Tx) error {
-		for _, table := range [][]byte{accountPrefix, tellerPrefix, branchPrefix, historyPrefix} {
-			lo.Must(tx.CreateBucketIfNotExists(table))
-		}
-		return nil
-	})
-
-	slog.Info("filling...", "scale", *scale)
-	if *initMode {
-		fill(db)
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
 	}
-
-	slog.Info("testing...")
-	var iterations uint64
-	var conflicts uint64
-	finishTimer, cancelFunc := context.WithTimeout(context.Background(), *benchtime)
-	defer cancelFunc()
-	var wg sync.WaitGroup
-	wg.Add(*concurrency)
-	for _ = range *concurrency {
-		go func() {
-			defer wg.Done()
-			for {
-				select {
-				case <-finishTimer.Done():
-					return
-				default:
-					atomic.AddUint64(&iterations, 1)
-					if *RWMode {
-						readWrite(db)
-					} else {
-						read(db)
-					}
-				}
-			}
-		}()
+	if err := validateFlags(); err != nil {
+		log.Fatal(err)
+	}
+	resolveSeed()
+	if err := run(flagValues(), start); err != nil {
+		log.Fatal(err)
 	}
-	wg.Wait()
-
-	slog.Info("throughtput results", "concurrency", *concurrency, "iterations", iterations, "conflicts", conflicts)
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Name", "Latency(us)", "Throughput(rps)"})
-	table.SetBorder(false)
-	table.SetHeaderLine(false)
-	table.SetRowLine(false)
-	testName := lo.Ternary(*RWMode, "tpcb-like", "tpcb-readonly")
-	latency := fmt.Sprintf("%0.3f", float64(benchtime.Microseconds())/float64(iterations)*float64(*concurrency))
-	throughput := fmt.Sprintf("%0.3f", float64(iterations)/benchtime.Seconds())
-	table.Append([]string{testName, latency, throughput})
-	table.Render()
-
 }
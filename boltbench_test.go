@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"github.com/ivagulin/boltbench/bench"
+	"github.com/samber/lo"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setFlags sets command line flags for the rest of the test, restoring them
+// when it ends
+func setFlags(t testing.TB, values map[string]string) {
+	t.Helper()
+	for name, v := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			t.Fatalf("no flag -%s", name)
+		}
+		old := f.Value.String()
+		if err := f.Value.Set(v); err != nil {
+			t.Fatalf("-%s=%s: %v", name, v, err)
+		}
+		t.Cleanup(func() { f.Value.Set(old) })
+	}
+}
+
+// setTestDataset points -db to a fresh database of 100 accounts, 10 tellers
+// and 3 branches in a temporary directory, filled when opened
+func setTestDataset(t testing.TB) {
+	t.Helper()
+	setFlags(t, map[string]string{
+		"db":                 filepath.Join(t.TempDir(), "test.db"),
+		"scale":              "1",
+		"accounts-per-scale": "100",
+		"tellers-per-scale":  "10",
+		"branches-per-scale": "3",
+		"seed":               "1",
+		"init":               "true",
+	})
+}
+
+func TestRunConfigKeepsConcurrencyForTheNextRun(t *testing.T) {
+	setTestDataset(t)
+	setFlags(t, map[string]string{
+		"single-writer":   "true",
+		"concurrency":     "2",
+		"benchtime":       "50ms",
+		"calibration":     "0",
+		"sweep-settle":    "0",
+		"report-interval": "0",
+		"bucket-stats":    "false",
+	})
+	// the second run stands for the B run of -compare
+	for run := range 2 {
+		results, err := runConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("run %d: %d results, want 1", run, len(results))
+		}
+		if results[0].Concurrency != 3 {
+			t.Fatalf("run %d: concurrency %d, want 2 readers and the writer", run, results[0].Concurrency)
+		}
+	}
+}
+
+func TestWriteComparisonPairsRateSweepPoints(t *testing.T) {
+	var results []bench.Result
+	for _, label := range []string{"A", "B"} {
+		for _, rate := range []float64{100, 200} {
+			tput := rate + lo.Ternary(label == "A", 1.0, 2.0)
+			results = append(results, bench.Result{Name: "tpcb", Label: label, Concurrency: 4, TargetRate: rate, Throughput: tput, Iterations: 1})
+		}
+	}
+	var out strings.Builder
+	writeComparison(&out, results)
+	for _, want := range [][]string{{"100.0", "101.000", "102.000"}, {"200.0", "201.000", "202.000"}} {
+		if !lo.SomeBy(strings.Split(out.String(), "\n"), func(line string) bool {
+			return lo.EveryBy(want, func(s string) bool { return strings.Contains(line, s) })
+		}) {
+			t.Errorf("no row with %q in\n%s", want, out.String())
+		}
+	}
+}
+
+func TestWriteOnelineKeepsTheKeyOrder(t *testing.T) {
+	var out strings.Builder
+	writeOneline(&out, []bench.Result{{Name: "tpcb", Concurrency: 4, Throughput: 100, RecordRate: 400, Iterations: 1, Latency: bench.LatencySummary{P99: 2 * time.Millisecond}, Label: "A"}})
+	want := "workload=tpcb conc=4 tput=100.0 p99=2.000ms ops=400.0 label=A\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestValidateFlagsChecksEveryPhase(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(schema, []byte(`{"buckets": [{"name": "users", "keys": 10}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name  string
+		flags map[string]string
+		ok    bool
+	}{
+		{"schema", map[string]string{"phases": "schema,point-read", "schema": schema}, true},
+		{"schema-verify", map[string]string{"phases": "point-read,schema", "schema": schema, "verify": "true"}, false},
+		{"blind-write-verify", map[string]string{"phases": "point-read,blind-write", "verify": "true"}, false},
+		{"pools", map[string]string{"phases": "tpcb,point-read", "single-writer": "true"}, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			setFlags(t, c.flags)
+			if err := validateFlags(); (err == nil) != c.ok {
+				t.Errorf("validateFlags() = %v, want ok %t", err, c.ok)
+			}
+		})
+	}
+}
+
+func TestWritePhaseComparisonComparesTheSameConcurrency(t *testing.T) {
+	var results []bench.Result
+	for phase, name := range []string{"point-read", "tpcb", "point-read"} {
+		for _, conc := range []int{1, 8} {
+			tput := float64(conc*1000 + phase)
+			results = append(results, bench.Result{Name: name, Phase: phase + 1, Concurrency: conc, Throughput: tput, Iterations: 1})
+		}
+	}
+	var out strings.Builder
+	writePhaseComparison(&out, results)
+	// phase 3 at concurrency 8 against phase 1 at 8, not at 1
+	for _, want := range [][]string{{"point-read", "3", "1", "1002.000", "+0.20%"}, {"point-read", "3", "8", "8002.000", "+0.03%"}} {
+		if !lo.SomeBy(strings.Split(out.String(), "\n"), func(line string) bool {
+			return lo.EveryBy(want, func(s string) bool { return strings.Contains(line, s) })
+		}) {
+			t.Errorf("no row with %q in\n%s", want, out.String())
+		}
+	}
+}
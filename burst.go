@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"github.com/olekukonko/tablewriter"
+	"github.com/samber/lo"
+	"io"
+	"strconv"
+	"time"
+)
+
+// writeBurstLatency compares the cold and warm operations of -burst-idle runs
+func writeBurstLatency(w io.Writer, results []bench.Result) {
+	if !lo.ContainsBy(results, func(r bench.Result) bool { return r.Burst != nil }) {
+		return
+	}
+	unit := displayUnit(results)
+	fmt.Fprintf(w, "\nlatency after idle periods\n")
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Name", "Concurrency", "Bursts", "Burst(ops/s)", "Part", "Count", unit.column("Latency"), unit.column("p50"), unit.column("p99"), unit.column("Max")})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	for _, r := range results {
+		b := r.Burst
+		if b == nil {
+			continue
+		}
+		for _, op := range []bench.OpSummary{b.Cold, b.Warm} {
+			format := lo.Ternary(op.Count > 0, unit.format, func(time.Duration) string { return "N/A" })
+			table.Append([]string{
+				r.Title(),
+				strconv.Itoa(r.Concurrency),
+				strconv.Itoa(b.Bursts),
+				fmt.Sprintf("%0.1f", b.BurstRate),
+				lo.Ternary(op.Op == "cold", fmt.Sprintf("first %s", b.ColdFor), "rest"),
+				strconv.Itoa(op.Count),
+				format(op.Latency.Mean),
+				format(op.Latency.P50),
+				format(op.Latency.P99),
+				format(op.Latency.Max),
+			})
+		}
+	}
+	table.Render()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"github.com/olekukonko/tablewriter"
+	"github.com/samber/lo"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// applyOverrides sets flags from a space separated list of name=value pairs,
+// a bare name sets a boolean flag to true
+func applyOverrides(overrides string) error {
+	for _, kv := range strings.Fields(overrides) {
+		name, value, ok := strings.Cut(strings.TrimLeft(kv, "-"), "=")
+		if !ok {
+			value = "true"
+		}
+		if name == "compare" {
+			return fmt.Errorf("-compare cannot override itself")
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("override %q: %w", kv, err)
+		}
+	}
+	return nil
+}
+
+func percentDelta(a, b float64) string {
+	if a == 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%+0.2f%%", (b-a)/a*100)
+}
+
+// comparisonKey identifies a measured window within a run, the same in A and
+// B unless the overrides change the sweep. The workload name is left out, so
+// that an override of -workload still pairs up.
+type comparisonKey struct {
+	phase       int
+	concurrency int
+	rate        float64
+}
+
+func comparisonKeyOf(r bench.Result) comparisonKey {
+	return comparisonKey{phase: r.Phase, concurrency: r.Concurrency, rate: r.TargetRate}
+}
+
+// writeComparison pairs the A and B results by phase, concurrency level and
+// target rate
+func writeComparison(w io.Writer, results []bench.Result) {
+	byKey := make(map[comparisonKey]bench.Result)
+	for _, r := range results {
+		if r.Label == "A" {
+			byKey[comparisonKeyOf(r)] = r
+		}
+	}
+	unit := displayUnit(results)
+	fmt.Fprintf(w, "\nB vs A\n")
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Name", "Concurrency", "Target(ops/s)", "Throughput A", "Throughput B", "Delta", unit.column("p99") + " A", unit.column("p99") + " B", "Delta"})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	for _, b := range results {
+		a, ok := byKey[comparisonKeyOf(b)]
+		if b.Label != "B" || !ok {
+			continue
+		}
+		table.Append([]string{
+			bench.Result{Name: b.Name, Phase: b.Phase}.Title(),
+			strconv.Itoa(b.Concurrency),
+			lo.Ternary(b.TargetRate > 0, fmt.Sprintf("%0.1f", b.TargetRate), "N/A"),
+			fmt.Sprintf("%0.3f", a.Throughput),
+			fmt.Sprintf("%0.3f", b.Throughput),
+			percentDelta(a.Throughput, b.Throughput),
+			formatLatency(a, unit, a.Latency.P99),
+			formatLatency(b, unit, b.Latency.P99),
+			percentDelta(float64(a.Latency.P99), float64(b.Latency.P99)),
+		})
+	}
+	table.Render()
+}
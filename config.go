@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var configPath = flag.String("config", "", "Load flag defaults from a .json object or a file of name=value lines; flags given on the command line take precedence")
+
+// loadConfig applies -config on top of the flag defaults. Flags set on the
+// command line keep their values, every key in the file must name a flag.
+func loadConfig() error {
+	if *configPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		return err
+	}
+	values, err := parseConfig(data, filepath.Ext(*configPath) == ".json")
+	if err != nil {
+		return fmt.Errorf("%s: %w", *configPath, err)
+	}
+	onCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+	for _, kv := range values {
+		if kv[0] == "config" {
+			return fmt.Errorf("%s: config files cannot include each other", *configPath)
+		}
+		if flag.Lookup(kv[0]) == nil {
+			return fmt.Errorf("%s: unknown flag %q", *configPath, kv[0])
+		}
+		if onCommandLine[kv[0]] {
+			continue
+		}
+		if err := flag.Set(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("%s: %s: %w", *configPath, kv[0], err)
+		}
+	}
+	return nil
+}
+
+// parseConfig returns the name, value pairs of the file. JSON values may be
+// strings, numbers or booleans.
+func parseConfig(data []byte, isJSON bool) ([][2]string, error) {
+	var values [][2]string
+	if isJSON {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, err
+		}
+		for name, raw := range obj {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				s = string(bytes.TrimSpace(raw))
+			}
+			values = append(values, [2]string{name, s})
+		}
+		return values, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected name=value", line)
+		}
+		values = append(values, [2]string{strings.TrimSpace(name), strings.TrimSpace(value)})
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"github.com/olekukonko/tablewriter"
+	"github.com/samber/lo"
+	"io"
+	"os"
+	"strconv"
+)
+
+// writePlan assumes the page size of a new database, the OS page size
+func writePlan(w io.Writer) error {
+	opts, err := benchOptions()
+	if err != nil {
+		return err
+	}
+	var schema []bench.SchemaBucket
+	if *workload == "schema" {
+		schema = opts.Schema
+	}
+	rows := bench.Plan(opts, schema)
+	pageSize := os.Getpagesize()
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Bucket", "Rows", "Row size(bytes)", "Rows/page", "Overflowing rows", "Estimated size(bytes)"})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	var total float64
+	for _, r := range rows {
+		size := float64(r.Count) * float64(r.Size) / bench.FillFactor
+		total += size
+		table.Append([]string{r.Name(), strconv.Itoa(r.Count), strconv.Itoa(r.Size), strconv.Itoa(r.RowsPerPage(pageSize)),
+			strconv.Itoa(lo.Ternary(r.Overflows(pageSize), r.Count, 0)), fmt.Sprintf("%0.0f", size)})
+	}
+	table.Append([]string{"total", "", "", "", "", fmt.Sprintf("%0.0f", total)})
+	table.Render()
+	fmt.Fprintf(w, "page size %d bytes, rows over %d bytes overflow\n", pageSize, pageSize-bench.PageHeaderSize)
+
+	fmt.Fprintf(w, "\nEffective flags\n")
+	flags := tablewriter.NewWriter(w)
+	flags.SetHeader([]string{"Flag", "Value"})
+	flags.SetBorder(false)
+	flags.SetHeaderLine(false)
+	flags.SetRowLine(false)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags.Append([]string{f.Name, f.Value.String()})
+	})
+	flags.Render()
+	return nil
+}
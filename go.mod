@@ -6,6 +6,7 @@ require (
 	github.com/boltdb/bolt v1.3.1
 	github.com/olekukonko/tablewriter v0.0.5
 	github.com/samber/lo v1.47.0
+	go.etcd.io/bbolt v1.3.11
 )
 
 require (
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"strconv"
+	"time"
+)
+
+// latencyUnit is the unit latencies are printed in
+type latencyUnit struct {
+	name string
+	size time.Duration
+}
+
+var (
+	nanos  = latencyUnit{"ns", time.Nanosecond}
+	micros = latencyUnit{"us", time.Microsecond}
+	millis = latencyUnit{"ms", time.Millisecond}
+
+	latencyUnits = map[string]latencyUnit{"ns": nanos, "us": micros, "ms": millis}
+)
+
+func checkLatencyUnit() error {
+	if _, ok := latencyUnits[*latencyUnitName]; !ok && *latencyUnitName != "auto" {
+		return fmt.Errorf("unknown latency unit %q", *latencyUnitName)
+	}
+	return nil
+}
+
+// displayUnit returns the -latency-unit of the tables of results. auto picks
+// the unit the largest median latency among them reads best in, so one table
+// keeps one unit.
+func displayUnit(results []bench.Result) latencyUnit {
+	if u, ok := latencyUnits[*latencyUnitName]; ok {
+		return u
+	}
+	var typical time.Duration
+	for _, r := range results {
+		typical = max(typical, r.Latency.P50)
+	}
+	switch {
+	case typical < time.Microsecond:
+		return nanos
+	case typical < time.Millisecond:
+		return micros
+	default:
+		return millis
+	}
+}
+
+func (u latencyUnit) format(d time.Duration) string {
+	if u.size == time.Nanosecond {
+		return strconv.FormatInt(d.Nanoseconds(), 10)
+	}
+	return fmt.Sprintf("%0.3f", float64(d)/float64(u.size))
+}
+
+// column labels a table column with the unit, like p99(us)
+func (u latencyUnit) column(name string) string {
+	return name + "(" + u.name + ")"
+}
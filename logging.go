@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"log/slog"
+	"os"
+)
+
+var (
+	logFormat = flag.String("log-format", "text", "Log format: text or json, logs always go to stderr")
+	logLevel  = flag.String("log-level", "info", "Minimum log level: debug, info, warn or error; debug also logs every result")
+	quiet     = flag.Bool("quiet", false, "Only log warnings and errors, raising -log-level to warn, so that the results stand out")
+)
+
+// setupLogging installs the -log-format handler as the slog default, which
+// routes the log package through it as well
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid -log-level %q", *logLevel)
+	}
+	if *quiet {
+		level = max(level, slog.LevelWarn)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch *logFormat {
+	case "text":
+		// keep the log package format of the default handler
+		slog.SetLogLoggerLevel(level)
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("unknown log format %q", *logFormat)
+	}
+	return nil
+}
+
+func logResults(results []bench.Result) {
+	for _, r := range results {
+		slog.Debug("result", "result", r)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// metricsBuckets is the number of histogram buckets exposed, the last one
+// ends at 2^31us (~36min), anything slower only shows up in +Inf
+const metricsBuckets = 32
+
+var current struct {
+	sync.Mutex
+	bn *bench.Bench
+}
+
+// setCurrent points /metrics at the Bench of the run in progress
+func setCurrent(bn *bench.Bench) {
+	current.Lock()
+	defer current.Unlock()
+	current.bn = bn
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	current.Lock()
+	defer current.Unlock()
+
+	var iterations, failed uint64
+	histogram := &bench.Histogram{}
+	if current.bn != nil {
+		iterations, failed, histogram = current.bn.Live()
+	}
+	counts := histogram.Counts()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP boltbench_iterations_total Operations started in the measured window.\n")
+	fmt.Fprintf(w, "# TYPE boltbench_iterations_total counter\n")
+	fmt.Fprintf(w, "boltbench_iterations_total %d\n", iterations)
+	fmt.Fprintf(w, "# HELP boltbench_errors_total Operations that failed in the measured window.\n")
+	fmt.Fprintf(w, "# TYPE boltbench_errors_total counter\n")
+	fmt.Fprintf(w, "boltbench_errors_total %d\n", failed)
+	fmt.Fprintf(w, "# HELP boltbench_op_latency_seconds Latency of successful operations.\n")
+	fmt.Fprintf(w, "# TYPE boltbench_op_latency_seconds histogram\n")
+	var cumulative uint64
+	for i := range metricsBuckets {
+		cumulative += counts[i]
+		le := strconv.FormatFloat(float64(uint64(1)<<i)/1e6, 'g', -1, 64)
+		fmt.Fprintf(w, "boltbench_op_latency_seconds_bucket{le=\"%s\"} %d\n", le, cumulative)
+	}
+	for i := metricsBuckets; i < len(counts); i++ {
+		cumulative += counts[i]
+	}
+	fmt.Fprintf(w, "boltbench_op_latency_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "boltbench_op_latency_seconds_sum %g\n", histogram.Sum().Seconds())
+	fmt.Fprintf(w, "boltbench_op_latency_seconds_count %d\n", cumulative)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"github.com/ivagulin/boltbench/bench"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+var (
+	distribution = flag.String("distribution", "uniform", "Key access distribution: uniform or zipfian")
+	zipfSkew     = flag.Float64("zipf-skew", 1.1, "Skew of the zipfian distribution, must be > 1")
+	seed         = flag.Uint64("seed", 0, "Seed of the fill and workload random sources, 0 picks a random one")
+
+	fillerMode        = flag.String("filler-mode", "repeat", "Filler content: zeros, repeat or random; json escapes zero bytes, making zeros filler 6 times larger on disk")
+	fillSizeAccounts  = flag.Int("fillsize-accounts", -1, "Filler size of account records, -1 uses -fillsize")
+	fillSizeTellers   = flag.Int("fillsize-tellers", -1, "Filler size of teller records, -1 uses -fillsize")
+	fillSizeBranches  = flag.Int("fillsize-branches", -1, "Filler size of branch records, -1 uses -fillsize")
+	fillSizeHistories = flag.Int("fillsize-history", -1, "Filler size of history records written by the workloads, -1 uses -fillsize")
+	prefillHistory    = flag.Int("prefill-history", 0, "Fill history with this many records, so that writes start on a tree of steady-state depth instead of an empty one")
+	schemaPath        = flag.String("schema", "", "JSON file of the buckets used by the schema workload, e.g. {\"buckets\": [{\"name\": \"users\", \"keys\": 100000, \"value_size\": 200}]}")
+
+	nested        = flag.Bool("nested", false, "Store accounts in one nested bucket per branch inside the accounts bucket instead of flat")
+	shards        = flag.Int("shards", 1, "Split every table into this many buckets, named like accounts:0, with keys routed by hash")
+	timeBreakdown = flag.Bool("time-breakdown", false, "Time transactions, transaction functions and Get/Put calls separately, at the cost of extra clock reads per call")
+	historyKey    = flag.String("history-key", "sequence", "How history keys are allocated: sequence uses the bucket NextSequence, counter an in memory atomic counter, to isolate the cost of the sequence update; compare both with -compare history-key=counter")
+	syncMode      = flag.String("sync-mode", "", "Commit durability: always fsyncs every commit, none never does, batched commits without fsync and syncs every -sync-interval; empty follows -nosync")
+	syncInterval  = flag.Duration("sync-interval", 100*time.Millisecond, "Interval between syncs of -sync-mode batched, the window of commits lost on a crash")
+
+	compactHistory   = flag.Bool("compact-history", false, "Trim history to -history-retention records in the background during the run, competing with the workload for the write lock")
+	historyRetention = flag.Int("history-retention", 100_000, "Number of newest history records kept by -compact-history")
+	compactInterval  = flag.Duration("compact-interval", time.Second, "Interval between -compact-history trims")
+	longReaders      = flag.Int("long-readers", 0, "Number of goroutines holding a read transaction open for -long-reader-hold at a time during the run, pinning the pages freed by writers")
+	longReaderHold   = flag.Duration("long-reader-hold", time.Second, "How long each -long-readers transaction stays open before the next one starts")
+	burstIdle        = flag.Duration("burst-idle", 0, "Alternate idle periods of this length without operations with -burst-length of flat-out load, starting with one; 0 runs steadily")
+	burstLength      = flag.Duration("burst-length", time.Second, "Length of the bursts between -burst-idle periods")
+	burstCold        = flag.Duration("burst-cold", 10*time.Millisecond, "Operations starting within this time of the start of a burst count as cold in the burst latency report")
+	tracePath        = flag.String("trace", "", "Write one JSON line per measured operation with its start time, type, latency and key to this file; verbose, off when empty")
+
+	boltNoSync         = flag.Bool("nosync", false, "Skip fsync after each commit")
+	boltNoGrowSync     = flag.Bool("nogrowsync", false, "Skip fsync when growing the file")
+	boltNoFreelistSync = flag.Bool("no-freelist-sync", false, "Do not persist the freelist on commit (bbolt only)")
+	boltFreelistType   = flag.String("freelist-type", "", "Freelist implementation: array or hashmap (bbolt only)")
+	boltInitialMmap    = flag.Int("initial-mmap", 0, "Initial mmap size in bytes")
+	boltMmapFlags      = flag.Int("mmap-flags", 0, "Extra flags passed to mmap, e.g. MAP_POPULATE")
+)
+
+// opTrace is the tracer of -trace, nil when tracing is off
+var opTrace *bench.Tracer
+
+// resolveSeed replaces an unset -seed with a random one and logs it, so that
+// any run can be repeated with the same keys touched in the same order
+func resolveSeed() {
+	if *seed == 0 {
+		*seed = rand.Uint64() | 1
+	}
+	slog.Info("using seed", "seed", *seed)
+}
+
+// benchOptions returns the bench.Options given on the command line, with the
+// -schema file loaded
+func benchOptions() (bench.Options, error) {
+	o := bench.Options{
+		DBPath:  *dbPath,
+		Backend: *backend,
+		Init:    *initMode,
+		Bolt: bench.BoltOptions{
+			NoSync:         *boltNoSync,
+			NoGrowSync:     *boltNoGrowSync,
+			NoFreelistSync: *boltNoFreelistSync,
+			FreelistType:   *boltFreelistType,
+			InitialMmap:    *boltInitialMmap,
+			MmapFlags:      *boltMmapFlags,
+		},
+		SyncMode:     *syncMode,
+		SyncInterval: *syncInterval,
+		Shards:       *shards,
+		Nested:       *nested,
+		Encoding:     *encoding,
+
+		Scale:            *scale,
+		AccountsPerScale: *accountsPerScale,
+		TellersPerScale:  *tellersPerScale,
+		BranchesPerScale: *branchesPerScale,
+		FillBatch:        *fillBatchSize,
+		FillerMode:       *fillerMode,
+		FillSize:         *fillSize,
+		FillSizeAccounts: *fillSizeAccounts,
+		FillSizeTellers:  *fillSizeTellers,
+		FillSizeBranches: *fillSizeBranches,
+		FillSizeHistory:  *fillSizeHistories,
+		PrefillHistory:   *prefillHistory,
+		Seed:             *seed,
+
+		RWMode:           *RWMode,
+		ReadPct:          *readPct,
+		FullRead:         *fullRead,
+		NoHistory:        *noHistory,
+		WritesPerTxn:     *writesPerTxn,
+		DeltaMin:         *deltaMin,
+		DeltaMax:         *deltaMax,
+		ScanLen:          *scanLen,
+		MultireadKeys:    *multireadKeys,
+		ChurnWindow:      *churnWindow,
+		DashboardHistory: *dashboardHistory,
+		DashboardDecode:  *dashboardDecode,
+		Distribution:     *distribution,
+		ZipfSkew:         *zipfSkew,
+		HistoryKey:       *historyKey,
+		WritePool:        poolWriters(),
+		MaxRetries:       *maxRetries,
+		RetryBackoff:     *retryBackoff,
+		RetryBackoffMax:  *retryBackoffMax,
+		Batch:            *batchMode,
+
+		SampleRate:       *sampleRate,
+		LatencyWindow:    *latencyWindow,
+		TimeBreakdown:    *timeBreakdown,
+		Trace:            opTrace,
+		BucketStats:      *bucketStats,
+		Verify:           *verify,
+		CheckHistory:     *checkHist,
+		CompactHistory:   *compactHistory,
+		HistoryRetention: *historyRetention,
+		CompactInterval:  *compactInterval,
+		LongReaders:      *longReaders,
+		LongReaderHold:   *longReaderHold,
+		BurstIdle:        *burstIdle,
+		BurstLength:      *burstLength,
+		BurstCold:        *burstCold,
+	}
+	if *schemaPath != "" {
+		schema, err := bench.LoadSchema(*schemaPath)
+		if err != nil {
+			return o, err
+		}
+		o.Schema = schema
+	}
+	return o, nil
+}
+
+// runOptions returns the bench.RunOptions given on the command line, the
+// sweep points then set the concurrency and rate
+func runOptions() bench.RunOptions {
+	return bench.RunOptions{
+		Concurrency: *concurrency,
+		Rate:        *rate,
+		Benchtime:   *benchtime,
+		Nops:        *nops,
+		Warmup:      *warmup,
+		Calibration: *calibration,
+		ReportEvery: *reportEvery,
+	}
+}
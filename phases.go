@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"github.com/olekukonko/tablewriter"
+	"github.com/samber/lo"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var phases = flag.String("phases", "", "Comma separated workloads to run one after another against the same database instead of -workload, e.g. point-read,tpcb,point-read to compare reads before and after writes")
+
+// phaseNames returns the workload names of -phases, or just -workload
+func phaseNames() []string {
+	if *phases == "" {
+		return []string{*workload}
+	}
+	return lo.Map(strings.Split(*phases, ","), func(name string, _ int) string { return strings.TrimSpace(name) })
+}
+
+// selectPhases returns the workloads of -phases, or just -workload, shaped
+// by o
+func selectPhases(o bench.Options) ([]bench.Workload, error) {
+	var workloads []bench.Workload
+	for _, name := range phaseNames() {
+		wl, err := bench.SelectWorkload(o, name)
+		if err != nil {
+			return nil, lo.Ternary(*phases == "", err, fmt.Errorf("-phases: %w", err))
+		}
+		workloads = append(workloads, wl)
+	}
+	return workloads, nil
+}
+
+// phaseKey identifies the runs of a workload at one sweep point across phases
+type phaseKey struct {
+	name        string
+	concurrency int
+	rate        float64
+}
+
+// writePhaseComparison compares every workload run in more than one phase
+// with its first run at the same sweep point, showing how the phases in
+// between changed it
+func writePhaseComparison(w io.Writer, results []bench.Result) {
+	first := make(map[phaseKey]bench.Result)
+	runs := make(map[phaseKey]int)
+	for _, r := range results {
+		if r.Phase == 0 || r.Label == "B" {
+			continue
+		}
+		key := phaseKey{name: r.Name, concurrency: r.Concurrency, rate: r.TargetRate}
+		if _, ok := first[key]; !ok {
+			first[key] = r
+		}
+		runs[key]++
+	}
+	if !lo.SomeBy(lo.Values(runs), func(n int) bool { return n > 1 }) {
+		return
+	}
+	unit := displayUnit(results)
+	fmt.Fprintf(w, "\nphases vs first phase of the workload\n")
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Name", "Phase", "Concurrency", "DB file(bytes)", "Throughput(rps)", "Delta", unit.column("p50"), unit.column("p99"), "Delta"})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	for _, r := range results {
+		key := phaseKey{name: r.Name, concurrency: r.Concurrency, rate: r.TargetRate}
+		base, ok := first[key]
+		if r.Phase == 0 || r.Label == "B" || !ok || runs[key] < 2 {
+			continue
+		}
+		size := ""
+		if r.Storage != nil {
+			size = strconv.FormatInt(r.Storage.FileSizeBefore, 10)
+		}
+		table.Append([]string{
+			r.Name,
+			strconv.Itoa(r.Phase),
+			strconv.Itoa(r.Concurrency),
+			size,
+			fmt.Sprintf("%0.3f", r.Throughput),
+			percentDelta(base.Throughput, r.Throughput),
+			formatLatency(r, unit, r.Latency.P50),
+			formatLatency(r, unit, r.Latency.P99),
+			percentDelta(float64(base.Latency.P99), float64(r.Latency.P99)),
+		})
+	}
+	table.Render()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+)
+
+var (
+	cpuProfile = flag.String("cpuprofile", "", "Write a CPU profile of the measured window to this file, later windows of a sweep or -compare go to file.2.ext and so on")
+	memProfile = flag.String("memprofile", "", "Write a heap profile taken at the end of the measured window to this file, numbered like -cpuprofile")
+)
+
+// profiledWindows counts the measured windows profiled so far
+var profiledWindows int
+
+// profilePath numbers path for the n-th window, keeping the first one as is
+func profilePath(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), n+1, ext)
+}
+
+// profiler is a probe that writes the -cpuprofile and -memprofile of the
+// measured window, leaving out fill and verification
+type profiler struct {
+	n   int
+	cpu *os.File
+}
+
+func (p *profiler) Start() error {
+	p.n = profiledWindows
+	profiledWindows++
+	if *cpuProfile == "" {
+		return nil
+	}
+	f, err := os.Create(profilePath(*cpuProfile, p.n))
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	p.cpu = f
+	return nil
+}
+
+func (p *profiler) Stop(r *bench.Result) error {
+	if p.cpu != nil {
+		pprof.StopCPUProfile()
+		if err := p.cpu.Close(); err != nil {
+			return err
+		}
+		slog.Info("wrote CPU profile", "path", p.cpu.Name())
+	}
+	if *memProfile == "" {
+		return nil
+	}
+	path := profilePath(*memProfile, p.n)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// collect first so the profile reflects live objects at the end of the window
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return err
+	}
+	slog.Info("wrote heap profile", "path", path)
+	return f.Close()
+}
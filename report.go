@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"github.com/olekukonko/tablewriter"
+	"github.com/samber/lo"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// percent formats a fraction as a percentage, with enough digits that a
+// handful of failures among millions of operations does not show as zero
+func percent(f float64) string {
+	return fmt.Sprintf("%0.4f%%", f*100)
+}
+
+// formatLatency renders d of r in unit, or N/A when no operation completed
+func formatLatency(r bench.Result, unit latencyUnit, d time.Duration) string {
+	if !r.Completed() {
+		return "N/A"
+	}
+	return unit.format(d)
+}
+
+// configFlags are shown above the results table, so that a pasted table says
+// which run produced it
+var configFlags = [][]string{
+	{"scale", "accounts-per-scale", "tellers-per-scale", "branches-per-scale", "concurrency", "read-workers", "write-workers", "single-writer", "concurrency-sweep", "benchtime", "nops", "rate", "rate-sweep", "burst-idle", "workload", "phases", "schema", "rwmode", "readpct", "encoding", "seed"},
+	{"backend", "nosync", "sync-mode", "nogrowsync", "no-freelist-sync", "freelist-type", "initial-mmap", "mmap-flags", "batch", "shards", "nested", "history-key", "long-readers"},
+}
+
+// writeConfig prints configFlags from flags, which are the values the run
+// started with, before -compare overrides. Flags still at their zero value
+// are left out.
+func writeConfig(w io.Writer, flags map[string]string) {
+	for _, names := range configFlags {
+		var pairs []string
+		for _, name := range names {
+			if v := flags[name]; v != "" && v != "0" && v != "-1" {
+				pairs = append(pairs, name+"="+v)
+			}
+		}
+		fmt.Fprintln(w, strings.Join(pairs, " "))
+	}
+	fmt.Fprintf(w, "engine=%s@%s", bench.EngineModule, bench.EngineVersion())
+	if *compareWith != "" {
+		fmt.Fprintf(w, " compare=%q", *compareWith)
+	}
+	fmt.Fprintf(w, "\n\n")
+}
+
+func writeTable(w io.Writer, flags map[string]string, results []bench.Result) {
+	writeConfig(w, flags)
+	unit := displayUnit(results)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Name", "Concurrency", unit.column("Min"), unit.column("Latency"), unit.column("StdDev"), unit.column("p50"), unit.column("p95"), unit.column("p99"), unit.column("Max"), "TPS(txn/s)", "OPS(op/s)", "Reads", "Writes", "Conflicts", "Conflicts(%)", "Errors", "Errors(%)"})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	for _, r := range results {
+		table.Append([]string{
+			r.Title(),
+			strconv.Itoa(r.Concurrency),
+			formatLatency(r, unit, r.Latency.Min),
+			formatLatency(r, unit, r.Latency.Mean),
+			formatLatency(r, unit, r.Latency.StdDev),
+			formatLatency(r, unit, r.Latency.P50),
+			formatLatency(r, unit, r.Latency.P95),
+			formatLatency(r, unit, r.Latency.P99),
+			formatLatency(r, unit, r.Latency.Max),
+			fmt.Sprintf("%0.3f", r.Throughput),
+			fmt.Sprintf("%0.3f", r.RecordRate),
+			strconv.FormatUint(r.Reads, 10),
+			strconv.FormatUint(r.Writes, 10),
+			strconv.FormatUint(r.Conflicts, 10),
+			percent(r.RetryRate()),
+			strconv.FormatUint(r.Errors, 10),
+			percent(r.ErrorRate()),
+		})
+	}
+	table.Render()
+	fmt.Fprintf(w, "\nTPS counts transactions, OPS the records they read, update, insert or delete\n")
+	for _, r := range results {
+		if !r.Completed() {
+			fmt.Fprintf(w, "\n%s: no operations completed, check -benchtime and the errors logged\n", r.Title())
+		}
+	}
+	writeOpBreakdown(w, results)
+	writeEngineStats(w, results)
+	writeLoadCurve(w, results)
+	writeLatencyWindows(w, results)
+	writePhaseComparison(w, results)
+	writeReaderScaling(w, results)
+	writeBurstLatency(w, results)
+
+	for _, r := range results {
+		if r.SampleRate > 0 {
+			fmt.Fprintf(w, "\n%s latencies and per operation counts are from a %0.2f%% random sample, throughput from all operations\n",
+				r.Title(), r.SampleRate*100)
+		}
+		if r.RowsPerOp > 0 {
+			fmt.Fprintf(w, "\n%s %0.1f txn/sec, %0.1f rows/sec at %d rows per txn\n",
+				r.Title(), r.Throughput, r.Throughput*float64(r.RowsPerOp), r.RowsPerOp)
+		}
+		if r.ReadWorkers > 0 {
+			seconds := r.Duration.Seconds()
+			fmt.Fprintf(w, "\n%s read pool of %d workers %0.1f reads/sec, write pool of %d workers %0.1f writes/sec\n",
+				r.Title(), r.ReadWorkers, float64(r.Reads)/seconds, r.WriteWorkers, float64(r.Writes)/seconds)
+		}
+		if r.TargetRate > 0 {
+			fmt.Fprintf(w, "\n%s achieved %0.1f of target %0.1f ops/sec%s\n",
+				r.Title(), r.Throughput, r.TargetRate, lo.Ternary(r.KeptUp(), "", ", could not keep up"))
+		}
+		if s := r.Storage; s != nil {
+			fmt.Fprintf(w, "\n%s db file %d -> %d bytes (%+d), history seq %d (+%d), %0.1f bytes/txn\n",
+				r.Title(), s.FileSizeBefore, s.FileSizeAfter, s.FileSizeAfter-s.FileSizeBefore,
+				s.HistorySeq, s.HistoryAdded, s.BytesPerTxn())
+			fmt.Fprintf(w, "%s free pages %d -> %d\n", r.Title(), r.FreePagesBefore, r.FreePagesAfter)
+		}
+		if m := r.Memory; m != nil {
+			fmt.Fprintf(w, "%s allocated %d bytes in %d mallocs, %d GCs paused %s, heap in use %d bytes\n",
+				r.Title(), m.TotalAlloc, m.Mallocs, m.NumGC, m.GCPause, m.HeapInuse)
+		}
+		if t := r.Time; t != nil {
+			fmt.Fprintf(w, "%s time per op (%s): total %s, transaction %s, commit %s, in transaction %s, get/put %s, encoding and logic %s\n",
+				r.Title(), unit.name, unit.format(r.Latency.Mean), unit.format(t.Txn), unit.format(t.Txn-t.Fn), unit.format(t.Fn), unit.format(t.KV), unit.format(t.Fn-t.KV))
+		}
+		if io, ok := r.IO(); ok {
+			fmt.Fprintf(w, "%s %0.1f txn/sec in %0.1f commits/sec, achieved %0.1f fsyncs/sec and %0.1f IOPS; commit writes take %s, allowing %0.1f commits/sec%s\n",
+				r.Title(), r.Throughput, io.CommitRate, io.FsyncRate, io.IOPS, io.CommitTime.Round(time.Microsecond), io.MaxCommits,
+				lo.Ternary(io.DeviceBound(), ", the device is the bottleneck", ""))
+		}
+		if s := r.Sync; s != nil {
+			fmt.Fprintf(w, "%s %s sync: %d syncs taking %s, commits of up to %s lost on a crash\n",
+				r.Title(), s.Mode, s.Syncs, s.Time.Round(time.Microsecond), s.Interval)
+		}
+		if l := r.LongReaders; l != nil {
+			fmt.Fprintf(w, "%s %d long readers held %d read transactions open for %s each\n", r.Title(), l.Readers, l.Txns, l.Hold)
+		}
+		if c := r.Compaction; c != nil {
+			fmt.Fprintf(w, "%s history compaction trimmed %d records in %d runs\n", r.Title(), c.Trimmed, c.Runs)
+		}
+		if b := r.Balances; b != nil {
+			fmt.Fprintf(w, "%s balance check %s: accounts %d (%+d for %+d applied), tellers %d, branches %d, history %d, imbalance %d\n",
+				r.Title(), lo.Ternary(b.OK(), "passed", "FAILED"), b.Accounts, b.AccountsChange, b.Applied, b.Tellers, b.Branches, b.HistoryDelta, b.Imbalance())
+		}
+		if h := r.HistoryCheck; h != nil {
+			fmt.Fprintf(w, "%s history check %s: %d rows added for %d sequence numbers\n",
+				r.Title(), lo.Ternary(h.OK(), "passed", "FAILED"), h.RowsAdded, h.SeqAdded)
+		}
+	}
+	for _, r := range results {
+		writeHistogram(w, r)
+		writeBucketStats(w, r)
+	}
+	if lo.ContainsBy(results, func(r bench.Result) bool { return r.Label == "B" }) {
+		writeComparison(w, results)
+	}
+}
+
+// writeOpBreakdown breaks latency down by operation type, it is only printed when
+// some workload mixes more than one
+func writeOpBreakdown(w io.Writer, results []bench.Result) {
+	if !lo.ContainsBy(results, func(r bench.Result) bool { return len(r.Ops) > 1 }) {
+		return
+	}
+	unit := displayUnit(results)
+	fmt.Fprintf(w, "\nlatency per operation\n")
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Name", "Concurrency", "Op", "Count", unit.column("Min"), unit.column("Latency"), unit.column("StdDev"), unit.column("p50"), unit.column("p95"), unit.column("p99"), unit.column("Max")})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	for _, r := range results {
+		for _, op := range r.Ops {
+			format := lo.Ternary(op.Count > 0, unit.format, func(time.Duration) string { return "N/A" })
+			table.Append([]string{
+				r.Title(),
+				strconv.Itoa(r.Concurrency),
+				op.Op,
+				strconv.Itoa(op.Count),
+				format(op.Latency.Min),
+				format(op.Latency.Mean),
+				format(op.Latency.StdDev),
+				format(op.Latency.P50),
+				format(op.Latency.P95),
+				format(op.Latency.P99),
+				format(op.Latency.Max),
+			})
+		}
+	}
+	table.Render()
+}
+
+// writeLoadCurve prints achieved throughput and latency against the target
+// rate, it is only printed when more than one rate was run. The p99 going up
+// sharply while the achieved rate stops following the target is where the
+// system saturates.
+func writeLoadCurve(w io.Writer, results []bench.Result) {
+	rates := lo.Uniq(lo.FilterMap(results, func(r bench.Result, _ int) (float64, bool) { return r.TargetRate, r.TargetRate > 0 }))
+	if len(rates) < 2 {
+		return
+	}
+	unit := displayUnit(results)
+	fmt.Fprintf(w, "\nlatency vs load\n")
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Name", "Concurrency", "Target(ops/s)", "Achieved(ops/s)", unit.column("p50"), unit.column("p99"), unit.column("Max"), "Kept up"})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	for _, r := range results {
+		if r.TargetRate == 0 {
+			continue
+		}
+		table.Append([]string{
+			r.Title(),
+			strconv.Itoa(r.Concurrency),
+			fmt.Sprintf("%0.1f", r.TargetRate),
+			fmt.Sprintf("%0.1f", r.Throughput),
+			formatLatency(r, unit, r.Latency.P50),
+			formatLatency(r, unit, r.Latency.P99),
+			formatLatency(r, unit, r.Latency.Max),
+			lo.Ternary(r.KeptUp(), "yes", "no"),
+		})
+	}
+	table.Render()
+}
+
+// writeLatencyWindows prints latency per -latency-window, it is only printed
+// when some run spans more than one
+func writeLatencyWindows(w io.Writer, results []bench.Result) {
+	if !lo.ContainsBy(results, func(r bench.Result) bool { return len(r.Windows) > 1 }) {
+		return
+	}
+	unit := displayUnit(results)
+	fmt.Fprintf(w, "\nlatency over time\n")
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Name", "Concurrency", "Window", "Count", "Throughput(rps)", unit.column("p50"), unit.column("p99"), unit.column("Max")})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	for _, r := range results {
+		for i, win := range r.Windows {
+			end := r.Duration
+			if i+1 < len(r.Windows) {
+				end = r.Windows[i+1].Start
+			}
+			table.Append([]string{
+				r.Title(),
+				strconv.Itoa(r.Concurrency),
+				fmt.Sprintf("%s-%s", win.Start, end.Round(time.Millisecond)),
+				strconv.Itoa(win.Count),
+				fmt.Sprintf("%0.3f", win.Throughput),
+				unit.format(win.P50),
+				unit.format(win.P99),
+				unit.format(win.Max),
+			})
+		}
+	}
+	table.Render()
+}
+
+// writeEngineStats prints the engine counters of the measured windows and
+// the freelist at their end
+func writeEngineStats(w io.Writer, results []bench.Result) {
+	if !lo.ContainsBy(results, func(r bench.Result) bool { return r.Engine != nil }) {
+		return
+	}
+	ms := func(d time.Duration) string { return fmt.Sprintf("%0.1f", float64(d)/float64(time.Millisecond)) }
+	fmt.Fprintf(w, "\nengine stats\n")
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Name", "Concurrency", "Free pages", "Pending pages", "Read txns", "Page allocs", "Alloc(bytes)", "Cursors", "Nodes", "Derefs", "Rebalances", "Rebalance(ms)", "Splits", "Spills", "Spill(ms)", "Writes", "Write(ms)", "Commits"})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	for _, r := range results {
+		e := r.Engine
+		if e == nil {
+			continue
+		}
+		table.Append([]string{
+			r.Title(),
+			strconv.Itoa(r.Concurrency),
+			strconv.Itoa(e.FreePages),
+			strconv.Itoa(e.PendingPages),
+			strconv.FormatInt(e.ReadTxns, 10),
+			strconv.FormatInt(e.PageCount, 10),
+			strconv.FormatInt(e.PageAlloc, 10),
+			strconv.FormatInt(e.CursorCount, 10),
+			strconv.FormatInt(e.NodeCount, 10),
+			strconv.FormatInt(e.NodeDeref, 10),
+			strconv.FormatInt(e.Rebalance, 10),
+			ms(e.RebalanceTime),
+			strconv.FormatInt(e.Split, 10),
+			strconv.FormatInt(e.Spill, 10),
+			ms(e.SpillTime),
+			strconv.FormatInt(e.Write, 10),
+			ms(e.WriteTime),
+			strconv.FormatInt(e.Commits, 10),
+		})
+	}
+	table.Render()
+}
+
+func writeBucketStats(w io.Writer, r bench.Result) {
+	if len(r.Buckets) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s bucket stats\n", r.Title())
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Bucket", "Keys", "Depth", "Branch pages", "Leaf pages", "Overflow pages", "Inline buckets", "Leaf in use(bytes)"})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	for _, b := range r.Buckets {
+		table.Append([]string{
+			b.Name,
+			strconv.Itoa(b.Keys),
+			strconv.Itoa(b.Depth),
+			strconv.Itoa(b.BranchPages),
+			strconv.Itoa(b.LeafPages),
+			strconv.Itoa(b.OverflowPages),
+			strconv.Itoa(b.InlineBuckets),
+			strconv.Itoa(b.LeafInuse),
+		})
+	}
+	table.Render()
+}
+
+const histogramBarWidth = 40
+
+func writeHistogram(w io.Writer, r bench.Result) {
+	if len(r.Histogram) == 0 {
+		return
+	}
+	var total, peak uint64
+	for _, b := range r.Histogram {
+		total += b.Count
+		peak = max(peak, b.Count)
+	}
+	fmt.Fprintf(w, "\n%s latency histogram\n", r.Title())
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Latency(us)", "Count", "Percent", ""})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	table.SetColumnAlignment([]int{tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT})
+	for _, b := range r.Histogram {
+		table.Append([]string{
+			fmt.Sprintf("%d - %d", b.FromUs, b.ToUs),
+			strconv.FormatUint(b.Count, 10),
+			fmt.Sprintf("%0.2f%%", float64(b.Count)*100/float64(total)),
+			strings.Repeat("#", int(b.Count*histogramBarWidth/peak)),
+		})
+	}
+	table.Render()
+}
+
+func writeJSON(w io.Writer, results []bench.Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// writeOneline prints one line of space separated key=value pairs per
+// result, for grep and awk. The keys are stable, new ones go after the
+// existing ones and before the trailing label, which is only present in
+// -compare runs.
+func writeOneline(w io.Writer, results []bench.Result) {
+	for _, r := range results {
+		label := lo.Ternary(r.Label == "", "", " label="+r.Label)
+		p99 := lo.Ternary(r.Completed(), fmt.Sprintf("%0.3fms", float64(r.Latency.P99)/float64(time.Millisecond)), "N/A")
+		fmt.Fprintf(w, "workload=%s conc=%d tput=%0.1f p99=%s ops=%0.1f%s\n",
+			r.Name, r.Concurrency, r.Throughput, p99, r.RecordRate, label)
+	}
+}
+
+func writeResults(w io.Writer, flags map[string]string, results []bench.Result) error {
+	switch *output {
+	case "table":
+		writeTable(w, flags, results)
+		return nil
+	case "json":
+		return writeJSON(w, results)
+	case "oneline":
+		writeOneline(w, results)
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+}
+
+var csvHeader = []string{"timestamp", "scale", "concurrency", "workload", "throughput", "p99_us"}
+
+// appendCSV reopens the file on every call, so rotating it between runs only
+// means the next run starts a fresh file with a header
+func appendCSV(path string, results []bench.Result) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return errors.Join(err, f.Close())
+	}
+	w := csv.NewWriter(f)
+	if st.Size() == 0 {
+		w.Write(csvHeader)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, r := range results {
+		w.Write([]string{
+			now,
+			strconv.Itoa(r.Scale),
+			strconv.Itoa(r.Concurrency),
+			r.Name,
+			fmt.Sprintf("%0.3f", r.Throughput),
+			formatLatency(r, micros, r.Latency.P99),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return errors.Join(err, f.Close())
+	}
+	return f.Close()
+}
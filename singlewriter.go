@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"github.com/olekukonko/tablewriter"
+	"github.com/samber/lo"
+	"io"
+	"strconv"
+)
+
+var singleWriter = flag.Bool("single-writer", false, "Run one worker doing only writes next to -concurrency workers doing only reads; -concurrency-sweep then sweeps the readers, printing how read throughput scales")
+
+// pools reports whether -read-workers and -write-workers or -single-writer
+// split the workers into a read and a write pool instead of each mixing reads
+// and writes
+func pools() bool {
+	return *singleWriter || *readWorkers > 0 || *writeWorkers > 0
+}
+
+// poolWriters is the size of the write pool, 0 without pools
+func poolWriters() int {
+	return lo.Ternary(*singleWriter, 1, *writeWorkers)
+}
+
+// writeReaderScaling compares the read throughput of -single-writer runs with
+// the first one with the same name, label and phase. Perfect scaling keeps the
+// reads per reader, an efficiency of 100%.
+func writeReaderScaling(w io.Writer, results []bench.Result) {
+	if !*singleWriter || len(results) < 2 {
+		return
+	}
+	unit := displayUnit(results)
+	fmt.Fprintf(w, "\nread scaling next to a single writer\n")
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Name", "Readers", "Reads(op/s)", "Per reader(op/s)", "Speedup", "Efficiency", "Read " + unit.column("p99"), "Writes(op/s)"})
+	table.SetBorder(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	first := make(map[string]bench.Result)
+	for _, r := range results {
+		base, ok := first[r.Title()]
+		if !ok {
+			first[r.Title()], base = r, r
+		}
+		seconds := r.Duration.Seconds()
+		reads, baseReads := float64(r.Reads)/seconds, float64(base.Reads)/base.Duration.Seconds()
+		speedup := lo.Ternary(baseReads > 0, reads/baseReads, 0)
+		// the read operation comes first in Ops
+		p99 := "N/A"
+		if len(r.Ops) > 0 && r.Ops[0].Count > 0 {
+			p99 = unit.format(r.Ops[0].Latency.P99)
+		}
+		table.Append([]string{
+			r.Title(),
+			strconv.Itoa(r.ReadWorkers),
+			fmt.Sprintf("%0.1f", reads),
+			fmt.Sprintf("%0.1f", reads/float64(max(r.ReadWorkers, 1))),
+			fmt.Sprintf("%0.2fx", speedup),
+			fmt.Sprintf("%0.0f%%", speedup*float64(base.ReadWorkers)/float64(max(r.ReadWorkers, 1))*100),
+			p99,
+			fmt.Sprintf("%0.1f", float64(r.Writes)/seconds),
+		})
+	}
+	table.Render()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"github.com/samber/lo"
+	"log/slog"
+)
+
+var (
+	p99Threshold  = flag.Duration("p99-threshold", 0, "Exit non-zero if the p99 latency of any result exceeds this, 0 disables the check")
+	minThroughput = flag.Float64("min-throughput", 0, "Exit non-zero if the throughput of any result is below this many ops/sec, 0 disables the check")
+)
+
+// checkSLA logs every result failing -p99-threshold or -min-throughput and
+// returns an error if there was any
+func checkSLA(results []bench.Result) error {
+	failed := 0
+	for _, r := range results {
+		if *p99Threshold > 0 && (!r.Completed() || r.Latency.P99 > *p99Threshold) {
+			slog.Error("p99 latency check failed", "result", r.Title(), "concurrency", r.Concurrency,
+				"p99", lo.Ternary(r.Completed(), r.Latency.P99.String(), "N/A"), "threshold", *p99Threshold)
+			failed++
+		}
+		if *minThroughput > 0 && r.Throughput < *minThroughput {
+			slog.Error("throughput check failed", "result", r.Title(), "concurrency", r.Concurrency,
+				"throughput", fmt.Sprintf("%0.1f", r.Throughput), "min", *minThroughput)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d performance checks failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/ivagulin/boltbench/bench"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+type BuildInfo struct {
+	GoVersion     string `json:"go_version,omitempty"`
+	Version       string `json:"version,omitempty"`
+	Revision      string `json:"vcs_revision,omitempty"`
+	RevisionTime  string `json:"vcs_time,omitempty"`
+	Modified      bool   `json:"vcs_modified,omitempty"`
+	Engine        string `json:"engine"`
+	EngineVersion string `json:"engine_version"`
+}
+
+type Summary struct {
+	Flags   map[string]string `json:"flags"`
+	Build   BuildInfo         `json:"build"`
+	Start   time.Time         `json:"start"`
+	End     time.Time         `json:"end"`
+	Results []bench.Result    `json:"results"`
+}
+
+func buildInfo() BuildInfo {
+	rv := BuildInfo{Engine: bench.EngineModule, EngineVersion: bench.EngineVersion()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return rv
+	}
+	rv.GoVersion = info.GoVersion
+	rv.Version = info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rv.Revision = s.Value
+		case "vcs.time":
+			rv.RevisionTime = s.Value
+		case "vcs.modified":
+			rv.Modified = s.Value == "true"
+		}
+	}
+	return rv
+}
+
+func flagValues() map[string]string {
+	flags := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	return flags
+}
+
+// writeSummary takes the flags as parsed from the command line, before -compare
+// overrides were applied
+func writeSummary(path string, flags map[string]string, start time.Time, results []bench.Result) error {
+	data, err := json.MarshalIndent(Summary{
+		Flags:   flags,
+		Build:   buildInfo(),
+		Start:   start,
+		End:     time.Now(),
+		Results: results,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
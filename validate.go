@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ivagulin/boltbench/bench"
+	"github.com/samber/lo"
+	"math"
+	"os"
+)
+
+// validateFlags rejects flag combinations that would otherwise only fail
+// halfway through a run, or not fail at all and produce meaningless numbers
+func validateFlags() error {
+	opts, err := benchOptions()
+	if err != nil {
+		return err
+	}
+	if _, err := bench.SelectWorkload(opts, *workload); err != nil {
+		return err
+	}
+	workloads, err := selectPhases(opts)
+	if err != nil {
+		return err
+	}
+	// the checks of a workload apply to it in any phase
+	names := phaseNames()
+	if err := opts.Check(); err != nil {
+		return err
+	}
+	if err := checkLatencyUnit(); err != nil {
+		return err
+	}
+	if _, err := sweepPoints(); err != nil {
+		return err
+	}
+	for _, wl := range workloads {
+		if pools() && (wl.Read == nil || wl.Write == nil) {
+			return fmt.Errorf("-read-workers, -write-workers and -single-writer need a workload with reads and writes, %s has only one", wl.Name)
+		}
+	}
+	for _, c := range []struct {
+		ok  bool
+		msg string
+	}{
+		{*concurrency > 0, "-concurrency must be > 0"},
+		{*readWorkers >= 0 && *writeWorkers >= 0, "-read-workers and -write-workers must be >= 0"},
+		{*readWorkers > 0 == (*writeWorkers > 0), "-read-workers and -write-workers must be given together"},
+		{*benchtime > 0, "-benchtime must be > 0"},
+		{*scale > 0, "-scale must be > 0"},
+		{*accountsPerScale > 0 && *tellersPerScale > 0 && *branchesPerScale > 0, "-accounts-per-scale, -tellers-per-scale and -branches-per-scale must be > 0"},
+		{*readPct == -1 || *readPct >= 0 && *readPct <= 100, "-readpct must be between 0 and 100"},
+		{*fillSize >= 0, "-fillsize must be >= 0"},
+		{min(*fillSizeAccounts, *fillSizeTellers, *fillSizeBranches, *fillSizeHistories) >= -1, "per record -fillsize flags must be >= 0, or -1 to use -fillsize"},
+		{*shards > 0, "-shards must be > 0"},
+		{*fillBatchSize > 0, "-fill-batch must be > 0"},
+		{*scanLen > 0, "-scanlen must be > 0"},
+		{*syncInterval > 0, "-sync-interval must be > 0"},
+		{*multireadKeys > 0, "-multiread-keys must be > 0"},
+		{*churnWindow > 0, "-churn-window must be > 0"},
+		{*prefillHistory >= 0, "-prefill-history must be >= 0"},
+		{*deltaMin <= *deltaMax, "-delta-min must be <= -delta-max"},
+		// keeps balances from overflowing int64 within billions of updates
+		// of one row, the branch row of -scale 1 sees every update
+		{*deltaMin >= math.MinInt32 && *deltaMax <= math.MaxInt32, "-delta-min and -delta-max must fit in int32"},
+		{!lo.Contains(names, "blind-write") || !*verify, "-verify does not apply to blind-write, which overwrites balances"},
+		{*writesPerTxn > 0, "-writes-per-txn must be > 0"},
+		{*dashboardHistory >= 0, "-dashboard-history must be >= 0"},
+		{*maxRetries >= 0, "-max-retries must be >= 0"},
+		{*rate >= 0, "-rate must be >= 0"},
+		{*sampleRate > 0 && *sampleRate <= 1, "-latency-sample-rate must be in (0, 1]"},
+		{*historyRetention > 0, "-history-retention must be > 0"},
+		{*compactInterval > 0, "-compact-interval must be > 0"},
+		{*burstIdle >= 0, "-burst-idle must be >= 0"},
+		{*burstIdle <= 0 || *rate == 0 && *rateSweep == "", "-burst-idle runs bursts flat out, it cannot be combined with -rate or -rate-sweep"},
+		{*burstLength > 0, "-burst-length must be > 0"},
+		{*burstCold > 0 && *burstCold <= *burstLength, "-burst-cold must be in (0, -burst-length]"},
+		{*longReaders >= 0, "-long-readers must be >= 0"},
+		{*longReaderHold > 0, "-long-reader-hold must be > 0"},
+		{!*compactHistory || !*checkHist, "-check-history does not apply with -compact-history, which deletes history rows"},
+		{*historyKey == "sequence" || !*checkHist, "-check-history needs -history-key sequence, counter keys of failed transactions leave gaps"},
+		{*p99Threshold >= 0, "-p99-threshold must be >= 0"},
+		{*minThroughput >= 0, "-min-throughput must be >= 0"},
+		{*schemaPath == "" || lo.Contains(names, "schema"), "-schema needs -workload schema or a schema phase"},
+		{!lo.Contains(names, "schema") || !*verify && !*checkHist && !*compactHistory, "-verify, -check-history and -compact-history need the TPC-B tables, not -workload schema"},
+		{*initMode || !*tmpDB, "-tmpdb needs -init, a temporary database starts out empty"},
+	} {
+		if !c.ok {
+			return errors.New(c.msg)
+		}
+	}
+	if !*initMode && !*tmpDB && !*dryRun {
+		if _, err := os.Stat(*dbPath); err != nil {
+			return fmt.Errorf("-init=false needs an existing database: %w", err)
+		}
+	}
+	return nil
+}